@@ -0,0 +1,166 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// An Actor is anything in the level that moves and animates, built from
+// components: a Body for physics, an Animator, Health, the Points it's worth
+// and a Think func for AI.  Player and Creature embed one and add only what
+// makes them different, and new kinds of actor can do the same.  Components
+// an actor has no use for are left at their zero values.
+type Actor struct {
+	Body *Body
+	Animator
+	Health
+	Points    int                          // Scored for killing it
+	HurtState int                          // Added to State while it can't be hurt after a hit
+	Think     func(result int, ms float32) // Moves it each update, if set
+}
+
+// Creates an actor the size of the first frame of texture, standing on y.
+// It starts with 1 HP.
+func (s *State) NewActor(texture string, x float32, y float32, t int) Actor {
+	var (
+		tex    = s.textures[texture]
+		width  = (tex.Frames[0][1] - tex.Frames[0][0]) * 2
+		height = tex.Height * 2
+	)
+	a := Actor{
+		Body: s.NewBody(texture, x, y-float32(height), width, height, t),
+		Animator: Animator{
+			NextFrame:  s.clock.Now(),
+			Animations: map[int]*Animation{},
+			clock:      s.clock,
+		},
+		Health: Health{
+			HP:    1,
+			clock: s.clock,
+		},
+	}
+	a.Body.SetFrame(0)
+	return a
+}
+
+// Takes damage and returns the HP left.
+func (a *Actor) Hurt(damage int) int {
+	if a.Invincible() {
+		return a.HP
+	}
+	if a.Health.Hurt(damage) > 0 {
+		a.State |= a.HurtState
+	}
+	return a.HP
+}
+
+// Called each update with what the actor's body hit.
+func (a *Actor) Update(result int, ms float32) {
+	if a.Health.Update() {
+		a.State &= 511 ^ a.HurtState
+	}
+	a.Animate(a.Body)
+	if a.Think != nil {
+		a.Think(result, ms)
+	}
+}
+
+// Animator plays the Animation for whatever State its actor is in.
+type Animator struct {
+	State        int
+	LastState    int
+	NextFrame    time.Time
+	FrameCounter int
+	Animations   map[int]*Animation
+	clock        Clock
+}
+
+// Shows the next frame when it's due, or straight away if the state has
+// changed.
+func (a *Animator) Animate(b *Body) {
+	if a.clock.Now().After(a.NextFrame) || a.LastState != a.State {
+		if anim, ok := a.Animations[a.State]; ok {
+			i := a.FrameCounter % anim.Len()
+			b.SetFrame(anim.Frames[i])
+			a.NextFrame = a.clock.Now().Add(anim.Duration)
+		}
+		a.FrameCounter = (a.FrameCounter + 1) % 1000
+		a.LastState = a.State
+	}
+}
+
+// Health is hit points, and a while after each hit when no more can be
+// taken.
+type Health struct {
+	HP         int
+	MaxHP      int           // Healing stops here, unless it's 0
+	HurtTime   time.Duration // How long after a hit nothing more can hurt
+	invincible bool
+	vincibleat time.Time
+	clock      Clock
+}
+
+func (h *Health) Invincible() bool {
+	return h.invincible
+}
+
+func (h *Health) SetInvincible() {
+	h.invincible = true
+	h.vincibleat = h.clock.Now().Add(h.HurtTime)
+}
+
+// Takes damage off HP and returns what's left, never less than 0.
+// Surviving makes it invincible for HurtTime.
+func (h *Health) Hurt(damage int) int {
+	if h.invincible {
+		return h.HP
+	}
+	h.HP -= damage
+	if h.HP > 0 {
+		h.SetInvincible()
+	} else {
+		h.HP = 0
+	}
+	return h.HP
+}
+
+// Adds to HP, up to MaxHP, and returns the new HP.
+func (h *Health) Heal(amount int) int {
+	h.HP += amount
+	if h.MaxHP > 0 && h.HP > h.MaxHP {
+		h.HP = h.MaxHP
+	}
+	return h.HP
+}
+
+func (h *Health) SetMaxHP(max int) {
+	if max < 0 {
+		max = 0
+	}
+	h.MaxHP = max
+	if h.HP > max {
+		h.HP = max
+	}
+}
+
+// Returns true when HurtTime has just run out.
+func (h *Health) Update() bool {
+	if h.invincible && h.clock.Now().After(h.vincibleat) {
+		h.invincible = false
+		return true
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	var (
+		clock = NewManualClock()
+		h     = Health{HP: 3, MaxHP: 3, HurtTime: time.Second, clock: clock}
+	)
+	if hp := h.Hurt(1); hp != 2 || !h.Invincible() {
+		t.Fatalf("Hurt left %v HP, invincible %v", hp, h.Invincible())
+	}
+	if hp := h.Hurt(1); hp != 2 {
+		t.Errorf("Hurt while invincible left %v HP", hp)
+	}
+	clock.Advance(2 * time.Second)
+	if !h.Update() || h.Invincible() {
+		t.Errorf("Still invincible after HurtTime")
+	}
+	if hp := h.Heal(5); hp != 3 {
+		t.Errorf("Heal went past MaxHP to %v", hp)
+	}
+	if hp := h.Hurt(5); hp != 0 || h.Invincible() {
+		t.Errorf("Killing hit left %v HP, invincible %v", hp, h.Invincible())
+	}
+}
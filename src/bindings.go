@@ -0,0 +1,324 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Bindings map keys and joystick buttons to actions, which are the INPUT_
+// bits the game steps with.  They're kept in a JSON file of action names:
+//
+//   {
+//     "keys": {"JUMP": ["UP", "W"], "LEFT": ["LEFT", "A"], ...},
+//     "buttons": {"JUMP": [0], "RUN": [2], ...},
+//     "joystick": 0,
+//     "deadzone": 0.3
+//   }
+//
+// Actions missing from the file keep their default bindings.  The joystick's
+// first axis always moves left and right, and pulling down on its second
+// axis is DOWN.
+//
+// A key drives one action at most.  PAUSE can't have the keys that move
+// through the pause menu, or they'd close it, and Esc is kept for PAUSE
+// since it gives up rebinding.
+
+type Action struct {
+	Name  string
+	Input int
+}
+
+// In the order the options menu lists them.
+var Actions = []Action{
+	{"JUMP", INPUT_JUMP},
+	{"DOWN", INPUT_DOWN},
+	{"LEFT", INPUT_LEFT},
+	{"RIGHT", INPUT_RIGHT},
+	{"RUN", INPUT_RUN},
+	{"THROW", INPUT_THROW},
+	{"PAUSE", INPUT_PAUSE},
+}
+
+// Names keys are given in the bindings file.  Letters and digits are named
+// by themselves.
+var KeyNames = map[string]int{
+	"UP":     twodee.KeyUp,
+	"DOWN":   twodee.KeyDown,
+	"LEFT":   twodee.KeyLeft,
+	"RIGHT":  twodee.KeyRight,
+	"SPACE":  twodee.KeySpace,
+	"ENTER":  twodee.KeyEnter,
+	"ESC":    twodee.KeyEsc,
+	"LSHIFT": twodee.KeyLshift,
+	"RSHIFT": twodee.KeyRshift,
+	"LCTRL":  twodee.KeyLctrl,
+	"RCTRL":  twodee.KeyRctrl,
+}
+
+func init() {
+	for c := 'A'; c <= 'Z'; c++ {
+		KeyNames[string(c)] = int(c)
+	}
+	for c := '0'; c <= '9'; c++ {
+		KeyNames[string(c)] = int(c)
+	}
+}
+
+// Keys the pause menu is driven with, which can't pause.
+var MenuKeys = []int{twodee.KeyUp, twodee.KeyDown, twodee.KeyEnter, twodee.KeySpace}
+
+func ActionName(input int) string {
+	for _, a := range Actions {
+		if a.Input == input {
+			return a.Name
+		}
+	}
+	return fmt.Sprintf("INPUT%v", input)
+}
+
+func KeyName(key int) string {
+	for name, k := range KeyNames {
+		if k == key {
+			return name
+		}
+	}
+	return fmt.Sprintf("KEY%v", key)
+}
+
+type Bindings struct {
+	Keys     map[int][]int // Keys for each action
+	Buttons  map[int][]int // Joystick buttons for each action
+	Joystick int           // Which joystick, from 0
+	DeadZone float32       // How far the stick moves before it counts
+	path     string
+}
+
+func DefaultBindings() *Bindings {
+	return &Bindings{
+		Keys: map[int][]int{
+			INPUT_JUMP:  {twodee.KeyUp, 'W', twodee.KeySpace},
+			INPUT_DOWN:  {twodee.KeyDown, 'S'},
+			INPUT_LEFT:  {twodee.KeyLeft, 'A'},
+			INPUT_RIGHT: {twodee.KeyRight, 'D'},
+			INPUT_RUN:   {twodee.KeyLshift, twodee.KeyRshift},
+			INPUT_THROW: {'X', twodee.KeyLctrl, twodee.KeyRctrl},
+			INPUT_PAUSE: {twodee.KeyEsc},
+		},
+		Buttons: map[int][]int{
+			INPUT_JUMP:  {0},
+			INPUT_RUN:   {2},
+			INPUT_THROW: {1},
+			INPUT_PAUSE: {7},
+		},
+		DeadZone: 0.3,
+	}
+}
+
+type bindingsFile struct {
+	Keys     map[string][]string `json:"keys"`
+	Buttons  map[string][]int    `json:"buttons"`
+	Joystick int                 `json:"joystick"`
+	DeadZone float32             `json:"deadzone"`
+}
+
+func actionInput(name string) (input int, err error) {
+	for _, a := range Actions {
+		if a.Name == name {
+			return a.Input, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown action %v", name)
+}
+
+// Reads bindings from path over the defaults.  A missing file just means
+// the defaults, and Save will create it.
+func LoadBindings(path string) (b *Bindings, err error) {
+	var (
+		f    *os.File
+		file bindingsFile
+	)
+	b = DefaultBindings()
+	b.path = path
+	if f, err = os.Open(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	file.Joystick = b.Joystick
+	file.DeadZone = b.DeadZone
+	if err = json.NewDecoder(f).Decode(&file); err != nil {
+		err = fmt.Errorf("%v: %v", path, err)
+		return
+	}
+	for name, keys := range file.Keys {
+		var input int
+		if input, err = actionInput(name); err != nil {
+			err = fmt.Errorf("%v: %v", path, err)
+			return
+		}
+		b.Keys[input] = []int{}
+		for _, key := range keys {
+			k, ok := KeyNames[key]
+			if !ok {
+				err = fmt.Errorf("%v: unknown key %v", path, key)
+				return
+			}
+			b.Keys[input] = append(b.Keys[input], k)
+		}
+	}
+	for name, buttons := range file.Buttons {
+		var input int
+		if input, err = actionInput(name); err != nil {
+			err = fmt.Errorf("%v: %v", path, err)
+			return
+		}
+		b.Buttons[input] = buttons
+	}
+	b.Joystick = file.Joystick
+	b.DeadZone = file.DeadZone
+	if err = b.Check(); err != nil {
+		err = fmt.Errorf("%v: %v", path, err)
+	}
+	return
+}
+
+// Returns an error if key can't be bound to the action.
+func CanBind(input int, key int) error {
+	if key == twodee.KeyEsc && input != INPUT_PAUSE {
+		return fmt.Errorf("ESC only pauses")
+	}
+	if input == INPUT_PAUSE {
+		for _, k := range MenuKeys {
+			if k == key {
+				return fmt.Errorf("%v moves through the pause menu", KeyName(key))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns an error if a key is bound to more than one action, or to one it
+// can't be.
+func (b *Bindings) Check() error {
+	actions := map[int]int{}
+	for _, a := range Actions {
+		for _, k := range b.Keys[a.Input] {
+			if err := CanBind(a.Input, k); err != nil {
+				return err
+			}
+			if other, ok := actions[k]; ok {
+				return fmt.Errorf("%v is bound to %v and %v", KeyName(k), ActionName(other), a.Name)
+			}
+			actions[k] = a.Input
+		}
+	}
+	return nil
+}
+
+func (b *Bindings) Save() (err error) {
+	var (
+		f    *os.File
+		file = bindingsFile{
+			Keys:     map[string][]string{},
+			Buttons:  map[string][]int{},
+			Joystick: b.Joystick,
+			DeadZone: b.DeadZone,
+		}
+	)
+	if b.path == "" {
+		return
+	}
+	for _, a := range Actions {
+		for _, k := range b.Keys[a.Input] {
+			file.Keys[a.Name] = append(file.Keys[a.Name], KeyName(k))
+		}
+		if buttons, ok := b.Buttons[a.Input]; ok {
+			file.Buttons[a.Name] = buttons
+		}
+	}
+	if f, err = os.Create(b.path); err != nil {
+		return
+	}
+	if err = json.NewEncoder(f).Encode(file); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// Makes key the only key for an action.  If another action had it, the keys
+// this action had go to that one instead, so it isn't left with none.
+// Returns an error, and changes nothing, if the key can't be bound or the
+// other action would be left without a key.
+func (b *Bindings) Bind(input int, key int) (err error) {
+	if err = CanBind(input, key); err != nil {
+		return
+	}
+	var (
+		old   = b.Keys[input]
+		other = 0
+		keys  []int
+	)
+	for _, a := range Actions {
+		if a.Input != input && b.Is(a.Input, key) {
+			other = a.Input
+		}
+	}
+	if other != 0 {
+		for _, k := range b.Keys[other] {
+			if k != key {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range old {
+			if CanBind(other, k) == nil {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("%v is the only key %v can have", KeyName(key), ActionName(other))
+		}
+		b.Keys[other] = keys
+	}
+	b.Keys[input] = []int{key}
+	return
+}
+
+// Returns true if key is bound to the action.
+func (b *Bindings) Is(input int, key int) bool {
+	for _, k := range b.Keys[input] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Describes the keys bound to an action, for menus.
+func (b *Bindings) Describe(input int) (desc string) {
+	for i, k := range b.Keys[input] {
+		if i > 0 {
+			desc += " "
+		}
+		desc += KeyName(k)
+	}
+	return
+}
@@ -0,0 +1,115 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	b := DefaultBindings()
+	// Space jumps, so jump takes throw's keys in exchange
+	if err := b.Bind(INPUT_THROW, twodee.KeySpace); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{twodee.KeyUp, 'W', 'X', twodee.KeyLctrl, twodee.KeyRctrl}
+	if !reflect.DeepEqual(b.Keys[INPUT_JUMP], want) {
+		t.Errorf("Jump has %v, want %v", b.Keys[INPUT_JUMP], want)
+	}
+	if err := b.Check(); err != nil {
+		t.Error(err)
+	}
+	for _, key := range MenuKeys {
+		if err := b.Bind(INPUT_PAUSE, key); err == nil {
+			t.Errorf("Bound %v to PAUSE", KeyName(key))
+		}
+	}
+	if err := b.Bind(INPUT_RUN, twodee.KeyEsc); err == nil {
+		t.Errorf("Bound ESC to RUN")
+	}
+	// Throw's only key is Space, which can't pause
+	if err := b.Bind(INPUT_PAUSE, 'P'); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Bind(INPUT_THROW, 'P'); err == nil {
+		t.Errorf("Left PAUSE without a key")
+	}
+	if !b.Is(INPUT_PAUSE, 'P') || !b.Is(INPUT_THROW, twodee.KeySpace) {
+		t.Errorf("A failed bind changed the bindings")
+	}
+}
+
+func TestLoadBindingsChecked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, data := range []string{
+		`{"keys": {"JUMP": ["X"]}}`,
+		`{"keys": {"PAUSE": ["ENTER"]}}`,
+		`{"keys": {"JUMP": ["UP", "ESC"]}}`,
+	} {
+		path := filepath.Join(dir, "bindings.json")
+		if err = ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadBindings(path); err == nil {
+			t.Errorf("Loaded %v", data)
+		}
+	}
+}
+
+type testJoystick struct {
+	axes    []float32
+	buttons []bool
+}
+
+func (j *testJoystick) Read(n int) ([]float32, []bool, bool) {
+	return j.axes, j.buttons, n == 0
+}
+
+func TestJoystickInput(t *testing.T) {
+	var (
+		b = DefaultBindings()
+		j = &testJoystick{
+			axes:    []float32{-0.5, -0.5},
+			buttons: make([]bool, 8),
+		}
+	)
+	j.buttons[0] = true
+	if got, want := JoystickInput(j, b), INPUT_LEFT|INPUT_DOWN|INPUT_JUMP; got != want {
+		t.Errorf("Got input %v, want %v", got, want)
+	}
+	j.axes = []float32{0.2, 0.2}
+	if got := JoystickInput(j, b); got != INPUT_JUMP {
+		t.Errorf("Got input %v inside the dead zone, want %v", got, INPUT_JUMP)
+	}
+	b.Joystick = 1
+	if got := JoystickInput(j, b); got != 0 {
+		t.Errorf("Got input %v from a joystick that isn't there", got)
+	}
+	b.Joystick = 0
+	var pause JoyButton
+	j.buttons[7] = true
+	if !pause.Pressed(j, b) || pause.Pressed(j, b) {
+		t.Errorf("Pause wasn't pressed just once while held")
+	}
+}
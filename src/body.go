@@ -0,0 +1,228 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"math"
+)
+
+type Rect struct {
+	MinX float32
+	MinY float32
+	MaxX float32
+	MaxY float32
+}
+
+func (r Rect) Overlaps(o Rect) bool {
+	return r.MinX < o.MaxX && o.MinX < r.MaxX &&
+		r.MinY < o.MaxY && o.MinY < r.MaxY
+}
+
+const (
+	SIDE_LEFT   = 1 << iota
+	SIDE_RIGHT  = 1 << iota
+	SIDE_TOP    = 1 << iota
+	SIDE_BOTTOM = 1 << iota
+	SIDE_ALL    = SIDE_LEFT | SIDE_RIGHT | SIDE_TOP | SIDE_BOTTOM
+)
+
+// Body is the simulated half of anything in the level.  Gameplay only ever
+// reads and moves Bodies, in env coordinates.  The Sprite is optional and
+// just follows the Body around when it's synced, which is what lets a State
+// run without a window.
+type Body struct {
+	X         float32
+	Y         float32
+	Width     float32
+	Height    float32
+	VelocityX float32
+	VelocityY float32
+	Collide   bool
+	Solid     int  // SIDE_ bits for the sides that stop other Bodies
+	Drop      bool // Falls through Bodies that are only partly solid
+	Grounded  bool // Was standing on something after the last update
+	Floating  bool // Not pulled down by gravity
+	Frame     int
+	Sprite    *twodee.Sprite
+	// Slopes are walked on from above along the line from SlopeLeft to
+	// SlopeRight, heights up from the bottom as a fraction of Height.
+	Slope      bool
+	SlopeLeft  float32
+	SlopeRight float32
+	lastx      float32
+	lasty      float32
+}
+
+func NewBody(x float32, y float32, w float32, h float32) *Body {
+	return &Body{
+		X:       x,
+		Y:       y,
+		Width:   w,
+		Height:  h,
+		Collide: true,
+		Solid:   SIDE_ALL,
+		lastx:   x,
+		lasty:   y,
+	}
+}
+
+func (b *Body) Bounds() Rect {
+	return Rect{b.X, b.Y, b.X + b.Width, b.Y + b.Height}
+}
+
+func (b *Body) Move(dx float32, dy float32) {
+	b.X += dx
+	b.Y += dy
+}
+
+func (b *Body) MoveTo(x float32, y float32) {
+	b.X = x
+	b.Y = y
+}
+
+// Returns true if moving by dx, dy would leave b clear of o.
+func (b *Body) TestMove(dx float32, dy float32, o *Body) bool {
+	r := b.Bounds()
+	r.MinX += dx
+	r.MaxX += dx
+	r.MinY += dy
+	r.MaxY += dy
+	return !r.Overlaps(o.Bounds())
+}
+
+// Finds when moving b by dx, dy would first touch o.  Returns the fraction
+// of the move made at that point and the normal of the side of o that was
+// hit.  A Body that already overlaps o along the move hits it at 0, unless
+// o is only solid on some sides, so things can pass up through platforms.
+func (b *Body) Sweep(dx float32, dy float32, o *Body) (t float32, nx float32, ny float32, hit bool) {
+	var (
+		r             = b.Bounds()
+		q             = o.Bounds()
+		inf           = float32(math.Inf(1))
+		entryx, exitx = -inf, inf
+		entryy, exity = -inf, inf
+		entry, exit   float32
+	)
+	switch {
+	case dx > 0:
+		entryx, exitx = (q.MinX-r.MaxX)/dx, (q.MaxX-r.MinX)/dx
+	case dx < 0:
+		entryx, exitx = (q.MaxX-r.MinX)/dx, (q.MinX-r.MaxX)/dx
+	case r.MaxX <= q.MinX || q.MaxX <= r.MinX:
+		return 1, 0, 0, false
+	}
+	switch {
+	case dy > 0:
+		entryy, exity = (q.MinY-r.MaxY)/dy, (q.MaxY-r.MinY)/dy
+	case dy < 0:
+		entryy, exity = (q.MaxY-r.MinY)/dy, (q.MinY-r.MaxY)/dy
+	case r.MaxY <= q.MinY || q.MaxY <= r.MinY:
+		return 1, 0, 0, false
+	}
+	entry = Max(entryx, entryy)
+	exit = Min(exitx, exity)
+	if entry >= exit || entry > 1 || exit <= 0 {
+		return 1, 0, 0, false
+	}
+	var side int
+	if entryx > entryy {
+		if dx > 0 {
+			nx, side = -1, SIDE_LEFT
+		} else {
+			nx, side = 1, SIDE_RIGHT
+		}
+	} else {
+		if dy > 0 {
+			ny, side = -1, SIDE_TOP
+		} else {
+			ny, side = 1, SIDE_BOTTOM
+		}
+	}
+	if o.Solid&side == 0 || (o.Solid != SIDE_ALL && entry < 0) {
+		return 1, 0, 0, false
+	}
+	return Max(entry, 0), nx, ny, true
+}
+
+// Returns the y of a slope's surface at x, if x is over the slope.
+func (b *Body) Surface(x float32) (y float32, ok bool) {
+	if !b.Slope || x < b.X || x >= b.X+b.Width {
+		return 0, false
+	}
+	h := Lerp(b.SlopeLeft, b.SlopeRight, (x-b.X)/b.Width)
+	return b.Y + b.Height*(1-h), true
+}
+
+// Returns the part of a slope that's solid to something moving by dx, dy,
+// and can only be hit from outside the slope.  That's the edge it would
+// walk into, as high as the slope is at that edge, or the underside if it's
+// moving up.  Moving down it's the top of the high edge, so a sprite can
+// stand on the corner before its middle is over the slope.  Returns nil
+// when there's nothing to hit.
+func (b *Body) SlopeSide(dx float32, dy float32) *Body {
+	var (
+		height = b.SlopeLeft
+		x      = b.X
+		width  = b.Width
+		side   int
+	)
+	switch {
+	case dx > 0:
+		side = SIDE_LEFT
+	case dx < 0:
+		height, side = b.SlopeRight, SIDE_RIGHT
+	case dy < 0:
+		height, side = 1, SIDE_BOTTOM
+	case dy > 0:
+		if b.SlopeRight > b.SlopeLeft {
+			height, x = b.SlopeRight, b.X+b.Width-1
+		}
+		width, side = 1, SIDE_TOP
+	}
+	if height <= 0 {
+		return nil
+	}
+	h := b.Height * height
+	o := NewBody(x, b.Y+b.Height-h, width, h)
+	o.Solid = side
+	return o
+}
+
+func (b *Body) CollidesWith(o *Body) bool {
+	return b.Bounds().Overlaps(o.Bounds())
+}
+
+func (b *Body) SetFrame(frame int) {
+	b.Frame = frame
+	if b.Sprite != nil {
+		b.Sprite.SetFrame(frame)
+	}
+}
+
+// Remembers where the Body was at the start of a step, so Sync can draw it
+// somewhere between there and where it ends up.
+func (b *Body) SavePosition() {
+	b.lastx = b.X
+	b.lasty = b.Y
+}
+
+// Moves the sprite, if any, alpha of the way from the Body's last saved
+// position to its current one.
+func (b *Body) Sync(alpha float32) {
+	if b.Sprite != nil {
+		b.Sprite.MoveTo(twodee.Pt(Lerp(b.lastx, b.X, alpha), Lerp(b.lasty, b.Y, alpha)))
+	}
+}
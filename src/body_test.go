@@ -0,0 +1,68 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSweepHits(t *testing.T) {
+	var (
+		b    = NewBody(0, 0, 10, 10)
+		wall = NewBody(50, 0, 10, 10)
+	)
+	tt, nx, ny, hit := b.Sweep(80, 0, wall)
+	if !hit || tt != 0.5 || nx != -1 || ny != 0 {
+		t.Errorf("Sweep into wall got %v %v %v %v", tt, nx, ny, hit)
+	}
+	if _, _, _, hit = b.Sweep(30, 0, wall); hit {
+		t.Errorf("Sweep short of wall hit it")
+	}
+	if _, _, _, hit = b.Sweep(0, 80, wall); hit {
+		t.Errorf("Sweep past wall hit it")
+	}
+}
+
+func TestSweepPlatform(t *testing.T) {
+	var (
+		b        = NewBody(0, 20, 10, 10)
+		platform = NewBody(0, 0, 10, 10)
+	)
+	platform.Solid = SIDE_TOP
+	if _, _, _, hit := b.Sweep(0, -30, platform); hit {
+		t.Errorf("Jumping up through a platform hit it")
+	}
+	b.MoveTo(0, -20)
+	tt, _, ny, hit := b.Sweep(0, 20, platform)
+	if !hit || tt != 0.5 || ny != -1 {
+		t.Errorf("Landing on a platform got %v %v %v", tt, ny, hit)
+	}
+}
+
+func TestSlopeSide(t *testing.T) {
+	slope := NewSlope(SLOPE_UP_LOW, 0, 0, 32, 32)
+	if side := slope.SlopeSide(1, 0); side != nil {
+		t.Errorf("Low edge of a rise is %v, want nothing", side.Bounds())
+	}
+	if side := slope.SlopeSide(-1, 0); side == nil || side.Bounds() != (Rect{0, 16, 32, 32}) || side.Solid != SIDE_RIGHT {
+		t.Errorf("High edge of a rise is %v", side)
+	}
+	if side := slope.SlopeSide(0, -1); side == nil || side.Bounds() != slope.Bounds() || side.Solid != SIDE_BOTTOM {
+		t.Errorf("Underside of a rise is %v", side)
+	}
+	if side := slope.SlopeSide(0, 1); side == nil || side.Bounds() != (Rect{31, 16, 32, 32}) || side.Solid != SIDE_TOP {
+		t.Errorf("Top of the high edge of a rise is %v", side)
+	}
+}
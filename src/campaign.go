@@ -0,0 +1,175 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// A campaign is an ordered list of level manifests, played one after the
+// other.  Winning a level unlocks the next, and how far the player has got
+// is kept in a progress file so the world map can offer those levels again.
+//
+//   {"name": "...", "levels": ["assets/level1.json", ...]}
+
+type Campaign struct {
+	Path     string   `json:"-"`
+	Name     string   `json:"name"`
+	Levels   []string `json:"levels"`
+	Unlocked int      `json:"-"` // How many levels can be picked
+}
+
+func LoadCampaign(path string) (c *Campaign, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	c = &Campaign{
+		Path:     path,
+		Unlocked: 1,
+	}
+	if err = json.NewDecoder(f).Decode(c); err != nil {
+		err = fmt.Errorf("%v: %v", path, err)
+		return
+	}
+	if len(c.Levels) == 0 {
+		err = fmt.Errorf("%v: no levels", path)
+	}
+	return
+}
+
+// A campaign of one level, for playing a level on its own.
+func SingleLevel(path string) *Campaign {
+	return &Campaign{
+		Path:     path,
+		Name:     path,
+		Levels:   []string{path},
+		Unlocked: 1,
+	}
+}
+
+// Lets level i be picked, if it exists.
+func (c *Campaign) Unlock(i int) {
+	if i >= len(c.Levels) {
+		i = len(c.Levels) - 1
+	}
+	if i+1 > c.Unlocked {
+		c.Unlocked = i + 1
+	}
+}
+
+// Progress files map campaign paths to the number of levels unlocked, so
+// one file serves every campaign.  A missing file just means no progress.
+func readProgress(path string) (progress map[string]int, err error) {
+	var f *os.File
+	progress = map[string]int{}
+	if f, err = os.Open(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&progress); err != nil {
+		err = fmt.Errorf("%v: %v", path, err)
+	}
+	return
+}
+
+func (c *Campaign) LoadProgress(path string) (err error) {
+	var progress map[string]int
+	if progress, err = readProgress(path); err != nil {
+		return
+	}
+	if n, ok := progress[c.Path]; ok {
+		c.Unlock(n - 1)
+	}
+	return
+}
+
+func (c *Campaign) SaveProgress(path string) (err error) {
+	var (
+		progress map[string]int
+		f        *os.File
+	)
+	if progress, err = readProgress(path); err != nil {
+		return
+	}
+	progress[c.Path] = c.Unlocked
+	if f, err = os.Create(path); err != nil {
+		return
+	}
+	if err = json.NewEncoder(f).Encode(progress); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// Plays the campaign from level start until a level isn't won or there are
+// none left, unlocking each level as the one before it is won.  play runs
+// level i and returns the state it ended in; stats is what the player
+// carries into it, nil for the first level played.
+func (c *Campaign) Play(start int, play func(i int, stats *Stats) (*State, error)) (state *State, err error) {
+	var stats *Stats
+	for i := start; i < len(c.Levels); i++ {
+		if state, err = play(i, stats); err != nil || !state.Victory {
+			return
+		}
+		c.Unlock(i + 1)
+		s := state.Stats()
+		stats = &s
+	}
+	return
+}
+
+// What the player takes with them from one level to the next.
+type Stats struct {
+	Score     int
+	NextLife  int
+	Lives     int
+	MaxLives  int
+	Health    int
+	MaxHealth int
+	Powerups  int
+}
+
+func (s *State) Stats() Stats {
+	return Stats{
+		Score:     s.score,
+		NextLife:  s.nextlife,
+		Lives:     s.livesbar.Available(),
+		MaxLives:  s.livesbar.Max(),
+		Health:    s.player.HP,
+		MaxHealth: s.player.MaxHP,
+		Powerups:  s.player.Powerups,
+	}
+}
+
+// Carries stats over from an earlier level.  Call after Start.
+func (s *State) SetStats(stats Stats) {
+	s.nextlife = stats.NextLife
+	s.livesbar.SetMax(stats.MaxLives)
+	s.livesbar.SetAvailable(stats.Lives)
+	s.player.SetMaxHP(stats.MaxHealth)
+	s.player.HP = stats.Health
+	s.ShowHealth()
+	s.SetScore(stats.Score)
+	s.player.Powerups = stats.Powerups
+	s.ShowPowerups()
+}
@@ -0,0 +1,107 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// Clock is where anything timed in the game gets the time from.  Game time
+// only moves when it is advanced, which State.Step does once per step, so
+// animations, invincibility and spawning all stop when the simulation does.
+type Clock interface {
+	Now() time.Time
+	Advance(d time.Duration)
+}
+
+func Ms(ms float32) time.Duration {
+	return time.Duration(ms * float32(time.Millisecond))
+}
+
+// GameClock keeps game time, and also measures how much real time has gone
+// by for the game loop to spend, stretched by the scale and stopped while
+// paused.
+type GameClock struct {
+	now    time.Time
+	real   time.Time
+	scale  float32
+	paused bool
+}
+
+func NewGameClock() *GameClock {
+	return &GameClock{
+		real:  time.Now(),
+		scale: 1,
+	}
+}
+
+func (c *GameClock) Now() time.Time {
+	return c.now
+}
+
+func (c *GameClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// Returns the scaled real time since the last call.
+func (c *GameClock) Elapsed() time.Duration {
+	var (
+		now     = time.Now()
+		elapsed = now.Sub(c.real)
+	)
+	c.real = now
+	if c.paused {
+		return 0
+	}
+	return time.Duration(float32(elapsed) * c.scale)
+}
+
+func (c *GameClock) Pause() {
+	c.paused = true
+}
+
+func (c *GameClock) Resume() {
+	c.paused = false
+}
+
+func (c *GameClock) Paused() bool {
+	return c.paused
+}
+
+// A scale of 0.5 runs the game at half speed.
+func (c *GameClock) SetScale(scale float32) {
+	c.scale = Max(scale, 0)
+}
+
+func (c *GameClock) Scale() float32 {
+	return c.scale
+}
+
+// ManualClock only moves when advanced, for headless runs and tests.
+type ManualClock struct {
+	now time.Time
+}
+
+func NewManualClock() *ManualClock {
+	return &ManualClock{}
+}
+
+func (c *ManualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,133 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Entities holds the creatures, pickups and projectiles in a level.  Adding
+// to or removing from a list while it's being looped over skips or repeats
+// entries, so Spawn and Despawn only queue the change.  Flush makes every
+// queued change in the order it was asked for, which State.Step does once
+// at the end of each step.  Everything in a step sees the same entities.
+
+type Entities struct {
+	Creatures   []*Creature
+	Pickups     []*Pickup
+	Projectiles []*Projectile
+	queue       []entityChange
+	add         func(*Body)
+	remove      func(*Body)
+}
+
+// Entity is anything Entities holds: a *Creature, *Pickup or *Projectile.
+// Nothing else has the methods, so nothing else can be spawned.
+type Entity interface {
+	body() *Body
+	join(e *Entities)
+	leave(e *Entities) bool // false if it wasn't there
+}
+
+type entityChange struct {
+	entity Entity
+	spawn  bool
+}
+
+// add and remove are called with the body of each entity as it joins or
+// leaves, so its sprite can be shown or hidden.
+func NewEntities(add func(*Body), remove func(*Body)) *Entities {
+	return &Entities{
+		Creatures:   []*Creature{},
+		Pickups:     []*Pickup{},
+		Projectiles: []*Projectile{},
+		add:         add,
+		remove:      remove,
+	}
+}
+
+// Queues an entity to join the level.
+func (e *Entities) Spawn(entity Entity) {
+	e.queue = append(e.queue, entityChange{entity, true})
+}
+
+// Queues an entity to leave the level.  Despawning one that isn't there,
+// or is already leaving, does nothing.
+func (e *Entities) Despawn(entity Entity) {
+	e.queue = append(e.queue, entityChange{entity, false})
+}
+
+func (e *Entities) Flush() {
+	for _, change := range e.queue {
+		switch {
+		case change.spawn:
+			change.entity.join(e)
+			e.add(change.entity.body())
+		case change.entity.leave(e):
+			e.remove(change.entity.body())
+		}
+	}
+	e.queue = e.queue[:0]
+}
+
+func (c *Creature) body() *Body {
+	return c.Body
+}
+
+func (c *Creature) join(e *Entities) {
+	e.Creatures = append(e.Creatures, c)
+}
+
+func (c *Creature) leave(e *Entities) bool {
+	for i, d := range e.Creatures {
+		if d == c {
+			e.Creatures = append(e.Creatures[:i], e.Creatures[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pickup) body() *Body {
+	return p.Body
+}
+
+func (p *Pickup) join(e *Entities) {
+	e.Pickups = append(e.Pickups, p)
+}
+
+func (p *Pickup) leave(e *Entities) bool {
+	for i, q := range e.Pickups {
+		if q == p {
+			e.Pickups = append(e.Pickups[:i], e.Pickups[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Projectile) body() *Body {
+	return p.Body
+}
+
+func (p *Projectile) join(e *Entities) {
+	e.Projectiles = append(e.Projectiles, p)
+}
+
+func (p *Projectile) leave(e *Entities) bool {
+	for i, q := range e.Projectiles {
+		if q == p {
+			e.Projectiles = append(e.Projectiles[:i], e.Projectiles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
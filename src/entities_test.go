@@ -0,0 +1,88 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func newTestCreature() *Creature {
+	return &Creature{Actor: Actor{Body: NewBody(0, 0, 1, 1)}}
+}
+
+// Checks spawns and despawns made while looping over a list wait for Flush,
+// and each is seen by add or remove once.
+func TestEntitiesDuringLoop(t *testing.T) {
+	var (
+		added   []*Body
+		removed []*Body
+		e       = NewEntities(
+			func(b *Body) { added = append(added, b) },
+			func(b *Body) { removed = append(removed, b) },
+		)
+		a = newTestCreature()
+		b = newTestCreature()
+	)
+	e.Spawn(a)
+	e.Spawn(b)
+	if len(e.Creatures) != 0 {
+		t.Fatalf("Spawned before Flush")
+	}
+	e.Flush()
+	spawned := []*Creature{}
+	looped := 0
+	for _, c := range e.Creatures {
+		looped++
+		e.Despawn(c)
+		e.Despawn(c)
+		d := newTestCreature()
+		spawned = append(spawned, d)
+		e.Spawn(d)
+	}
+	if looped != 2 || len(e.Creatures) != 2 || e.Creatures[0] != a || e.Creatures[1] != b {
+		t.Fatalf("Changes made while looping showed up before Flush")
+	}
+	e.Flush()
+	if len(e.Creatures) != 2 || e.Creatures[0] != spawned[0] || e.Creatures[1] != spawned[1] {
+		t.Errorf("Got creatures %v, want %v", e.Creatures, spawned)
+	}
+	if len(added) != 4 {
+		t.Errorf("add called %v times, want 4", len(added))
+	}
+	if len(removed) != 2 || removed[0] != a.Body || removed[1] != b.Body {
+		t.Errorf("remove called with %v, want each despawned body once", removed)
+	}
+}
+
+// Checks queued changes are made in the order they were asked for.
+func TestEntitiesInOrder(t *testing.T) {
+	var (
+		e = NewEntities(func(*Body) {}, func(*Body) {})
+		p = &Projectile{Body: NewBody(0, 0, 1, 1)}
+		q = &Pickup{Body: NewBody(0, 0, 1, 1)}
+	)
+	e.Spawn(p)
+	e.Despawn(p)
+	e.Spawn(p)
+	e.Spawn(q)
+	e.Despawn(q)
+	e.Flush()
+	if len(e.Projectiles) != 1 || e.Projectiles[0] != p {
+		t.Errorf("Spawn, despawn, spawn left projectiles %v", e.Projectiles)
+	}
+	if len(e.Pickups) != 0 {
+		t.Errorf("Spawn, despawn left pickups %v", e.Pickups)
+	}
+}
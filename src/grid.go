@@ -0,0 +1,90 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+)
+
+type Cell struct {
+	X int
+	Y int
+}
+
+// Grid buckets Bodies that don't move by the cells they cover, so finding
+// what's near a sprite doesn't mean looking at the whole level.  Cells are
+// the size of a level block.
+type Grid struct {
+	cellwidth  float32
+	cellheight float32
+	cells      map[Cell][]*Body
+	count      int
+}
+
+func NewGrid(cellwidth float32, cellheight float32) *Grid {
+	return &Grid{
+		cellwidth:  cellwidth,
+		cellheight: cellheight,
+		cells:      map[Cell][]*Body{},
+	}
+}
+
+// Returns the first and last cells covered by r.
+func (g *Grid) span(r Rect) (min Cell, max Cell) {
+	min.X = int(math.Floor(float64(r.MinX / g.cellwidth)))
+	min.Y = int(math.Floor(float64(r.MinY / g.cellheight)))
+	max.X = int(math.Ceil(float64(r.MaxX/g.cellwidth))) - 1
+	max.Y = int(math.Ceil(float64(r.MaxY/g.cellheight))) - 1
+	return
+}
+
+func (g *Grid) Add(b *Body) {
+	min, max := g.span(b.Bounds())
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			c := Cell{x, y}
+			g.cells[c] = append(g.cells[c], b)
+		}
+	}
+	g.count++
+}
+
+func (g *Grid) Len() int {
+	return g.count
+}
+
+// Appends every Body sharing a cell with r to found, in row order, and
+// returns it.  Bodies spanning several cells are only added once.
+func (g *Grid) Query(r Rect, found []*Body) []*Body {
+	min, max := g.span(r)
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			for _, b := range g.cells[Cell{x, y}] {
+				// Only count b in the first queried cell it covers
+				first, _ := g.span(b.Bounds())
+				if first.X < min.X {
+					first.X = min.X
+				}
+				if first.Y < min.Y {
+					first.Y = min.Y
+				}
+				if first.X == x && first.Y == y {
+					found = append(found, b)
+				}
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,42 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestGridQuery(t *testing.T) {
+	var (
+		g    = NewGrid(32, 32)
+		a    = NewBody(0, 0, 32, 32)
+		wide = NewBody(64, 0, 96, 32) // Covers three cells
+		far  = NewBody(320, 320, 32, 32)
+	)
+	g.Add(a)
+	g.Add(wide)
+	g.Add(far)
+	if g.Len() != 3 {
+		t.Fatalf("Len is %v, want 3", g.Len())
+	}
+	found := g.Query(Rect{0, 0, 160, 32}, nil)
+	if len(found) != 2 || found[0] != a || found[1] != wide {
+		t.Errorf("Query found %v, want a and wide once each", found)
+	}
+	found = g.Query(Rect{300, 300, 310, 310}, found[:0])
+	if len(found) != 0 {
+		t.Errorf("Query of an empty cell found %v", found)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// Headless mode runs the real game logic with no window or GL context.
+// Textures are only measured so sprites get the same bounds they would have
+// on screen, and the level map is read straight from its PNG.
+//
+// The game logic still shares package main with the windowed game, which
+// imports twodee, so building it, headless or not, needs the GL and GLFW
+// libraries installed.  Only a display and a GPU aren't needed to run it.
+
+const (
+	HEADLESS_WIDTH  = 800
+	HEADLESS_HEIGHT = 600
+)
+
+func LoadImage(path string) (img image.Image, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	img, err = png.Decode(f)
+	return
+}
+
+// Measures a texture the way twodee slices it into frames.  A width of 0
+// means the top row of the image marks each frame with a run of opaque
+// pixels; otherwise every frame is width pixels wide.
+func LoadTextureMetrics(path string, width int) (t *twodee.Texture, err error) {
+	var img image.Image
+	if img, err = LoadImage(path); err != nil {
+		return
+	}
+	var (
+		b      = img.Bounds()
+		frames = [][]int{}
+		height = b.Dy()
+	)
+	if width > 0 {
+		for x := b.Min.X; x+width <= b.Max.X; x += width {
+			frames = append(frames, []int{x - b.Min.X, x - b.Min.X + width})
+		}
+	} else {
+		start := -1
+		for x := b.Min.X; x <= b.Max.X; x++ {
+			opaque := false
+			if x < b.Max.X {
+				_, _, _, a := img.At(x, b.Min.Y).RGBA()
+				opaque = a > 0x8000
+			}
+			switch {
+			case opaque && start < 0:
+				start = x - b.Min.X
+			case !opaque && start >= 0:
+				frames = append(frames, []int{start, x - b.Min.X})
+				start = -1
+			}
+		}
+		height -= 1
+	}
+	t = &twodee.Texture{
+		Frames: frames,
+		Height: height,
+	}
+	return
+}
+
+func SameColor(a color.Color, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+// Reads the level map in opts and calls the handler of every block it finds,
+// without creating any sprites.  Returns the size of the level.
+func LoadLevel(opts twodee.EnvOpts) (width float32, height float32, err error) {
+	var img image.Image
+	if img, err = LoadImage(opts.MapPath); err != nil {
+		return
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.At(x, y)
+			for _, block := range opts.Blocks {
+				if SameColor(c, block.Color) {
+					bx := float32((x - b.Min.X) * opts.BlockWidth)
+					by := float32((y - b.Min.Y) * opts.BlockHeight)
+					block.Handler(block, nil, bx, by)
+					break
+				}
+			}
+		}
+	}
+	width = float32(b.Dx() * opts.BlockWidth)
+	height = float32(b.Dy() * opts.BlockHeight)
+	return
+}
+
+func InitHeadless(clock Clock, path string) (state *State, err error) {
+	state = NewState(clock, HEADLESS_WIDTH, HEADLESS_HEIGHT)
+	var opts twodee.EnvOpts
+	if opts, err = state.SetLevel(path); err != nil {
+		return
+	}
+	for _, t := range state.level.Textures {
+		if state.textures[t.Name], err = LoadTextureMetrics(t.Path, t.Width); err != nil {
+			return
+		}
+	}
+	if state.width, state.height, err = LoadLevel(opts); err != nil {
+		return
+	}
+	if err = state.CheckMap(); err != nil {
+		return
+	}
+	state.Start()
+	return
+}
+
+// Sets the state of a key for CheckKeys when there's no system to poll.
+func (s *State) SetKey(key int, state int) {
+	s.keys[key] = state
+}
+
+// Steps a headless game until it ends or steps runs out, then prints how it
+// went.  Returns the number of steps taken.
+func RunHeadless(state *State, steps int, ms float32) int {
+	state.UpdateViewport(0)
+	i := 0
+	for ; i < steps && state.Running(); i++ {
+		state.Step(ms)
+	}
+	fmt.Printf("level %v seed %v steps %v score %v lives %v health %v victory %v\n",
+		state.level.Path, state.Seed(), i, state.Score(), state.livesbar.Available(), state.player.HP, state.Victory)
+	return i
+}
@@ -0,0 +1,142 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gl
+// +build gl
+
+package main
+
+import (
+	"./twodee"
+	"reflect"
+	"testing"
+)
+
+// The tests in this file need a display, so only run with go test -tags gl.
+func openTestSystem(t *testing.T) (*twodee.System, *twodee.Window) {
+	system, err := twodee.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := &twodee.Window{Width: 64, Height: 64, Title: "test"}
+	if err = system.Open(window); err != nil {
+		system.Terminate()
+		t.Fatal(err)
+	}
+	return system, window
+}
+
+// Checks LoadTextureMetrics slices every texture the levels use into the
+// same frames twodee does, so headless bodies are the size they are on
+// screen.
+func TestTextureMetrics(t *testing.T) {
+	system, _ := openTestSystem(t)
+	defer system.Terminate()
+	for _, path := range []string{"assets/level1.json", "assets/level2.json"} {
+		level, err := LoadLevelManifest(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tex := range level.Textures {
+			if err = system.LoadTexture(tex.Name, tex.Path, twodee.IntNearest, tex.Width); err != nil {
+				t.Fatal(err)
+			}
+			var (
+				want = system.Textures[tex.Name]
+				got  *twodee.Texture
+			)
+			if got, err = LoadTextureMetrics(tex.Path, tex.Width); err != nil {
+				t.Fatal(err)
+			}
+			if got.Height != want.Height || !reflect.DeepEqual(got.Frames, want.Frames) {
+				t.Errorf("%v is %v high with frames %v, twodee makes it %v high with %v",
+					tex.Path, got.Height, got.Frames, want.Height, want.Frames)
+			}
+		}
+	}
+}
+
+// Checks a texture is only loaded again when a level gives its name a
+// different path or width.
+func TestLoadTextures(t *testing.T) {
+	system, _ := openTestSystem(t)
+	defer system.Terminate()
+	var (
+		narrow = TexInfo{"blocks", "assets/level-textures.png", 16}
+		wide   = TexInfo{"blocks", "assets/level-textures.png", 32}
+	)
+	if err := LoadTextures(system, []TexInfo{narrow}); err != nil {
+		t.Fatal(err)
+	}
+	first := system.Textures["blocks"]
+	if err := LoadTextures(system, []TexInfo{narrow}); err != nil {
+		t.Fatal(err)
+	}
+	if system.Textures["blocks"] != first {
+		t.Errorf("Loaded %v again", narrow)
+	}
+	if err := LoadTextures(system, []TexInfo{wide}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(system.Textures["blocks"].Frames); got != len(first.Frames)/2 {
+		t.Errorf("Got %v frames after loading %v, want %v", got, wide, len(first.Frames)/2)
+	}
+}
+
+// testScreen runs for a number of paints, then moves on to next.
+type testScreen struct {
+	name   string
+	paints int
+	next   func() Screen
+	log    *[]string
+}
+
+func (s *testScreen) Running() bool { return s.paints > 0 }
+func (s *testScreen) Paint()        { s.paints--; *s.log = append(*s.log, s.name) }
+func (s *testScreen) Resume()       { *s.log = append(*s.log, "resume "+s.name) }
+
+func (s *testScreen) Next() Screen {
+	if s.next == nil {
+		return nil
+	}
+	return s.next()
+}
+
+// Checks a pushed screen pops back to the one under it, which resumes, and
+// that a screen replacing another comes in behind a transition.
+func TestScreenStack(t *testing.T) {
+	system, window := openTestSystem(t)
+	defer system.Terminate()
+	var (
+		log    []string
+		stack  = NewScreenStack(system, window)
+		pause  = &testScreen{name: "pause", paints: 1, log: &log}
+		second = &testScreen{name: "second", paints: 1, log: &log}
+		first  = &testScreen{name: "first", paints: 2, log: &log}
+	)
+	first.next = func() Screen { return second }
+	stack.Push(first)
+	stack.screens[0].Paint()
+	stack.Push(pause)
+	stack.Run()
+	want := []string{
+		"resume first", "first",
+		"resume pause", "pause",
+		"resume first", "first",
+		"resume second", "resume second", "second",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("Got %v, want %v", log, want)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"testing"
+)
+
+func TestHeadlessWalk(t *testing.T) {
+	s, err := InitHeadless(NewManualClock(), "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateViewport(0)
+	// Let the player settle on the floor before moving
+	for i := 0; i < 120; i++ {
+		s.Step(STEP_MS)
+	}
+	var (
+		x = s.player.Body.X
+		y = s.player.Body.Y
+	)
+	s.SetKey(twodee.KeyRight, 1)
+	for i := 0; i < 120; i++ {
+		s.Step(STEP_MS)
+	}
+	if s.player.Body.X <= x {
+		t.Errorf("Holding right moved the player from %v to %v", x, s.player.Body.X)
+	}
+	if s.player.Body.Y != y {
+		t.Errorf("Walking along the floor moved the player from y %v to %v", y, s.player.Body.Y)
+	}
+	if !s.Running() || s.livesbar.Available() != 1 {
+		t.Errorf("Walking lost a life: running %v lives %v", s.Running(), s.livesbar.Available())
+	}
+}
+
+func TestHeadlessFall(t *testing.T) {
+	s, err := InitHeadless(NewManualClock(), "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateViewport(0)
+	// With one life, falling off the level ends the game
+	s.player.Body.MoveTo(s.player.Body.X, s.height+2000)
+	s.Step(STEP_MS)
+	if s.Running() || s.livesbar.Available() != 0 {
+		t.Errorf("Falling off left running %v lives %v", s.Running(), s.livesbar.Available())
+	}
+}
+
+func TestViewportFollows(t *testing.T) {
+	s, err := InitHeadless(NewManualClock(), "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateViewport(0)
+	// Dying, so both axes ease towards the player without overshooting
+	s.player.Die()
+	s.player.Body.Move(300, -200)
+	var (
+		x = s.screenx
+		y = s.screeny
+	)
+	for i := 0; i < 120; i++ {
+		s.UpdateViewport(STEP_MS)
+		if s.screenx > x || s.screeny < y {
+			t.Fatalf("step %v: view went back from %v,%v to %v,%v", i, x, y, s.screenx, s.screeny)
+		}
+		x, y = s.screenx, s.screeny
+	}
+	s.UpdateViewport(0)
+	if s.screenx != x || s.screeny != y {
+		t.Errorf("view stopped at %v,%v short of %v,%v", x, y, s.screenx, s.screeny)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Joystick input goes through the Joystick interface, so the game never
+// talks to the joystick library itself, and tests can give it a fake.  The
+// game reads GLFWJoystick, in joystick_glfw.go, once there's a window.
+
+const JOYSTICK_BUTTONS = 32
+
+type Joystick interface {
+	// Returns the position of each axis of joystick n, from -1 to 1 with
+	// up positive, and whether each button is down.  ok is false if it
+	// isn't plugged in.
+	Read(n int) (axes []float32, buttons []bool, ok bool)
+}
+
+// Returns the actions joystick j is giving, if it's plugged in.
+func JoystickInput(j Joystick, b *Bindings) (input int) {
+	if j == nil {
+		return
+	}
+	axes, buttons, ok := j.Read(b.Joystick)
+	if !ok {
+		return
+	}
+	if len(axes) >= 2 {
+		switch {
+		case axes[0] < -b.DeadZone:
+			input |= INPUT_LEFT
+		case axes[0] > b.DeadZone:
+			input |= INPUT_RIGHT
+		}
+		if axes[1] < -b.DeadZone {
+			input |= INPUT_DOWN
+		}
+	}
+	for action, list := range b.Buttons {
+		for _, button := range list {
+			if button >= 0 && button < len(buttons) && buttons[button] {
+				input |= action
+			}
+		}
+	}
+	return
+}
+
+// JoyButton notices the joystick's pause button going down, since there's
+// no callback for joystick buttons the way there is for keys.
+type JoyButton struct {
+	down bool
+}
+
+// Returns true if the pause button has gone down since the last call.
+func (p *JoyButton) Pressed(j Joystick, b *Bindings) bool {
+	down := JoystickInput(j, b)&INPUT_PAUSE != 0
+	pressed := down && !p.down
+	p.down = down
+	return pressed
+}
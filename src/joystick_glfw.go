@@ -0,0 +1,42 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/jteeuwen/glfw"
+)
+
+// twodee doesn't wrap joysticks, and it lives in a submodule outside this
+// tree, so GLFWJoystick is the one place the game uses GLFW itself, ready to
+// move into twodee as it is.  It only works once twodee has initialized
+// GLFW, so never headless.
+
+type GLFWJoystick struct{}
+
+func (GLFWJoystick) Read(n int) (axes []float32, buttons []bool, ok bool) {
+	joy := glfw.Joy1 + n
+	if glfw.JoystickParam(joy, glfw.Present) == 0 {
+		return
+	}
+	axes = make([]float32, glfw.JoystickParam(joy, glfw.Axes))
+	axes = axes[:glfw.JoystickPos(joy, axes)]
+	down := make([]byte, JOYSTICK_BUTTONS)
+	down = down[:glfw.JoystickButtons(joy, down)]
+	buttons = make([]bool, len(down))
+	for i, d := range down {
+		buttons[i] = d == glfw.KeyPress
+	}
+	return axes, buttons, true
+}
@@ -0,0 +1,203 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+)
+
+// A level is described by a JSON manifest next to its map, so new levels
+// don't need a new build.  Paths in the manifest are relative to the working
+// directory, like every other asset path.
+//
+//   {
+//     "name": "...",
+//     "map": "assets/level-fw.png",
+//     "textures": [{"name": "...", "path": "...", "width": 16}, ...],
+//     "blocktexture": "level-textures",
+//     "blockwidth": 32,
+//     "blockheight": 32,
+//     "clearcolor": [r, g, b, a],
+//     "background": "texture name, drawn behind the level",
+//     "victory": "goal",
+//     "blocks": [{"color": [r, g, b], "type": "FLOOR", "frame": 0}, ...]
+//   }
+//
+// Anything else in a manifest is an error rather than ignored, so a setting
+// the game doesn't support yet, like music, isn't silently dropped.  The
+// textures in RequiredTextures must always be declared, since the game draws
+// with them whatever the level.
+
+const (
+	VICTORY_GOAL = "goal" // Touch a GOAL block
+	VICTORY_EDGE = "edge" // Reach the right hand side of the map, the default
+)
+
+type TexInfo struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Width int    `json:"width"`
+}
+
+type BlockInfo struct {
+	Color   [3]uint8 `json:"color"`
+	Type    string   `json:"type"`
+	Frame   int      `json:"frame"`
+	Comment string   `json:"comment"`
+}
+
+type Level struct {
+	Path         string      `json:"-"`
+	Name         string      `json:"name"`
+	Map          string      `json:"map"`
+	Textures     []TexInfo   `json:"textures"`
+	BlockTexture string      `json:"blocktexture"`
+	BlockWidth   int         `json:"blockwidth"`
+	BlockHeight  int         `json:"blockheight"`
+	ClearColor   [4]uint8    `json:"clearcolor"`
+	Background   string      `json:"background"`
+	Victory      string      `json:"victory"`
+	Blocks       []BlockInfo `json:"blocks"`
+}
+
+var RequiredTextures = []string{
+	"darwin-textures",
+	"enemy-textures",
+	"enemy-sm-textures",
+	"powerups-textures",
+	"projectile-textures",
+	"font1-textures",
+}
+
+// Names the block types can be given in a manifest.
+var BlockTypes = map[string]int{
+	"FLOOR":           FLOOR,
+	"START":           START,
+	"BADGUY":          BADGUY,
+	"PLATFORM":        PLATFORM,
+	"SLOPE_UP":        SLOPE_UP,
+	"SLOPE_DOWN":      SLOPE_DOWN,
+	"SLOPE_UP_LOW":    SLOPE_UP_LOW,
+	"SLOPE_UP_HIGH":   SLOPE_UP_HIGH,
+	"SLOPE_DOWN_HIGH": SLOPE_DOWN_HIGH,
+	"SLOPE_DOWN_LOW":  SLOPE_DOWN_LOW,
+	"GOAL":            GOAL,
+	"CHECKPOINT":      CHECKPOINT,
+	"COIN":            COIN,
+	"HEART":           HEART,
+	"ONEUP":           ONEUP,
+	"POWERUP":         POWERUP,
+}
+
+func LoadLevelManifest(path string) (l *Level, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	l = &Level{
+		Path:        path,
+		BlockWidth:  32,
+		BlockHeight: 32,
+		ClearColor:  [4]uint8{0, 0, 0, 255},
+		Victory:     VICTORY_EDGE,
+	}
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err = d.Decode(l); err != nil {
+		err = fmt.Errorf("%v: %v", path, err)
+		return
+	}
+	if l.Map == "" {
+		err = fmt.Errorf("%v: no map", path)
+		return
+	}
+	if l.BlockTexture == "" {
+		err = fmt.Errorf("%v: no blocktexture", path)
+		return
+	}
+	declared := map[string]bool{}
+	for _, t := range l.Textures {
+		declared[t.Name] = true
+	}
+	needed := append([]string{l.BlockTexture}, RequiredTextures...)
+	if l.Background != "" {
+		needed = append(needed, l.Background)
+	}
+	for _, name := range needed {
+		if !declared[name] {
+			err = fmt.Errorf("%v: texture %v isn't declared", path, name)
+			return
+		}
+	}
+	switch l.Victory {
+	case VICTORY_GOAL, VICTORY_EDGE:
+	default:
+		err = fmt.Errorf("%v: unknown victory %v", path, l.Victory)
+		return
+	}
+	for _, b := range l.Blocks {
+		if _, ok := BlockTypes[b.Type]; !ok {
+			err = fmt.Errorf("%v: unknown block type %v", path, b.Type)
+			return
+		}
+	}
+	return
+}
+
+// What each texture in the system was last loaded from.  Levels share most
+// textures, and they stay loaded between them, but a level can give a name
+// another used a different path or width, and then it has to be reloaded.
+var loadedtextures = map[string]TexInfo{}
+
+// Loads textures into system, skipping any it already has loaded the same
+// way.
+func LoadTextures(system *twodee.System, textures []TexInfo) (err error) {
+	for _, t := range textures {
+		if loaded, ok := loadedtextures[t.Name]; ok && loaded == t {
+			continue
+		}
+		if err = system.LoadTexture(t.Name, t.Path, twodee.IntNearest, t.Width); err != nil {
+			return
+		}
+		loadedtextures[t.Name] = t
+	}
+	return
+}
+
+// Builds the options to load the level's map with, calling handler for
+// every block found.
+func (l *Level) EnvOpts(handler func(*twodee.EnvBlock, *twodee.Sprite, float32, float32)) twodee.EnvOpts {
+	blocks := make([]*twodee.EnvBlock, len(l.Blocks))
+	for i, b := range l.Blocks {
+		blocks[i] = &twodee.EnvBlock{
+			Color:      color.RGBA{b.Color[0], b.Color[1], b.Color[2], 255},
+			Type:       BlockTypes[b.Type],
+			FrameIndex: b.Frame,
+			Handler:    handler,
+		}
+	}
+	return twodee.EnvOpts{
+		Blocks:      blocks,
+		TextureName: l.BlockTexture,
+		MapPath:     l.Map,
+		BlockWidth:  l.BlockWidth,
+		BlockHeight: l.BlockHeight,
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testStart = [3]uint8{0, 0, 0}
+	testFloor = [3]uint8{153, 102, 0}
+	testGoal  = [3]uint8{255, 204, 0}
+)
+
+// Writes a copy of level1's manifest, with changes made to its keys, to dir.
+// A nil change deletes the key.  If blocks are given the map is replaced by
+// one with each block in a row above a floor.  Returns the manifest's path.
+func writeTestLevel(t *testing.T, dir string, changes map[string]interface{}, blocks ...[3]uint8) string {
+	data, err := ioutil.ReadFile("assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := map[string]interface{}{}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) > 0 {
+		img := image.NewRGBA(image.Rect(0, 0, len(blocks), 2))
+		for x, b := range blocks {
+			img.Set(x, 0, color.RGBA{b[0], b[1], b[2], 255})
+			img.Set(x, 1, color.RGBA{testFloor[0], testFloor[1], testFloor[2], 255})
+		}
+		mappath := filepath.Join(dir, "map.png")
+		f, err := os.Create(mappath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err = png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		manifest["map"] = mappath
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(manifest, k)
+		} else {
+			manifest[k] = v
+		}
+	}
+	if data, err = json.Marshal(manifest); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "level.json")
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLevelManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err = LoadLevelManifest(writeTestLevel(t, dir, nil)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadLevelManifest(writeTestLevel(t, dir, map[string]interface{}{"victory": nil}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Victory != VICTORY_EDGE {
+		t.Errorf("Victory defaults to %v, want %v", l.Victory, VICTORY_EDGE)
+	}
+	var (
+		textures = []map[string]interface{}{
+			{"name": "level-textures", "path": "assets/level-textures.png", "width": 16},
+		}
+		tests = []struct {
+			name    string
+			changes map[string]interface{}
+			want    string
+		}{
+			{"no map", map[string]interface{}{"map": nil}, "no map"},
+			{"music", map[string]interface{}{"music": "assets/theme.ogg"}, "music"},
+			{"textures", map[string]interface{}{"textures": textures}, "darwin-textures"},
+			{"background", map[string]interface{}{"background": "sky"}, "sky"},
+			{"victory", map[string]interface{}{"victory": "time"}, "victory"},
+		}
+	)
+	for _, test := range tests {
+		_, err = LoadLevelManifest(writeTestLevel(t, dir, test.changes))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got error %v, want one about %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLevelMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		victory string
+		blocks  [][3]uint8
+		ok      bool
+	}{
+		{VICTORY_EDGE, [][3]uint8{testFloor, testFloor}, false},
+		{VICTORY_EDGE, [][3]uint8{testStart, testFloor}, true},
+		{VICTORY_EDGE, [][3]uint8{testStart, testStart}, false},
+		{VICTORY_GOAL, [][3]uint8{testStart, testFloor}, false},
+		{VICTORY_GOAL, [][3]uint8{testStart, testGoal}, true},
+	}
+	for i, test := range tests {
+		changes := map[string]interface{}{"victory": test.victory}
+		path := writeTestLevel(t, dir, changes, test.blocks...)
+		if _, err = InitHeadless(NewManualClock(), path); (err == nil) != test.ok {
+			t.Errorf("map %v: got error %v", i, err)
+		}
+	}
+}
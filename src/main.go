@@ -15,12 +15,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"./twodee"
-	"image/color"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -36,6 +37,35 @@ const (
 	DEBUG = false
 )
 
+const (
+	INPUT_JUMP  = 1 << iota
+	INPUT_DOWN  = 1 << iota
+	INPUT_LEFT  = 1 << iota
+	INPUT_RIGHT = 1 << iota
+	INPUT_RUN   = 1 << iota
+	INPUT_THROW = 1 << iota
+	INPUT_PAUSE = 1 << iota // Never stepped with, so never recorded
+)
+
+const (
+	STEP_MS      = float32(1000.0 / 120.0) // Simulation runs at 120Hz
+	MAX_FRAME_MS = float32(250)            // Drop time rather than spiral
+)
+
+// How the view follows the player.  The follow rates are the fraction of
+// the distance to the player left after each millisecond, so the view moves
+// the same however long a step is.
+const (
+	CAMERA_FOLLOW   = 0.94  // Sideways, and either way while dying
+	CAMERA_FOLLOW_Y = 0.998 // Up and down, slower so jumps don't jolt the view
+	CAMERA_MIN_Y    = 0.06  // Slowest the view moves up or down, in px per ms
+)
+
+const (
+	END_MS       = float32(2000) // How long the end of a level plays out
+	HEALTH_BONUS = 100           // Points for each heart left at the end
+)
+
 func Check(err error) {
 	if err != nil {
 		fmt.Printf("[error]: %v\n", err)
@@ -64,13 +94,19 @@ func Abs(a float32) float32 {
 	return a
 }
 
+// Rounds to the nearest whole number, halves up.
 func Round(a float32) float32 {
-	if a > 0 {
-		a += 0.5
-	} else {
-		a -= 0.5
-	}
-	return float32(math.Floor(float64(a)))
+	return float32(math.Floor(float64(a) + 0.5))
+}
+
+func Lerp(a float32, b float32, t float32) float32 {
+	return a + (b-a)*t
+}
+
+// Returns how much of the way to its target something should move in ms,
+// when rate of the distance is left after each millisecond.
+func Follow(rate float32, ms float32) float32 {
+	return Min(Max(1-float32(math.Pow(float64(rate), float64(ms))), 0), 1)
 }
 
 type LivesBar struct {
@@ -128,6 +164,9 @@ func (l *LivesBar) Max() int {
 
 func (l *LivesBar) Render() {
 	l.Clear()
+	if l.system == nil {
+		return
+	}
 	var (
 		x  int             = 0
 		t  *twodee.Texture = l.system.Textures["powerups-textures"]
@@ -156,6 +195,9 @@ const (
 	PLAYER_STOPPED = 1 << iota
 	PLAYER_WALKING = 1 << iota
 	PLAYER_JUMPING = 1 << iota
+	PLAYER_RUNNING = 1 << iota
+	CREATURE_HURT  = 1 << iota
+	CREATURE_DYING = 1 << iota
 )
 
 type Animation struct {
@@ -175,142 +217,256 @@ func (a *Animation) Len() int {
 }
 
 type Player struct {
-	Sprite       *twodee.Sprite
-	State        int
-	LastState    int
-	JumpSpeed    float32
-	WalkSpeed    float32
-	RunSpeed     float32
-	Acceleration float32
-	Deceleration float32
-	NextFrame    time.Time
-	FrameCounter int
-	Animations   map[int]*Animation
-	StartX       float32
-	StartY       float32
-	invincible   bool
-	vincibleat   time.Time
+	Actor
+	JumpSpeed       float32
+	StartSpeed      float32 // Speed a standing player sets off at
+	WalkSpeed       float32
+	RunSpeed        float32 // Top speed while run is held
+	Acceleration    float32
+	Deceleration    float32
+	AirDeceleration float32 // Lower, so jumps carry their speed
+	SpeedBoost      float32 // Top speeds are multiplied by this with POWERUP_SPEED
+	ThrowSpeed      float32
+	ThrowLift       float32       // Upward speed a throw starts with
+	ThrowDelay      time.Duration // Shortest time between throws
+	Powerups        int
+	JumpCut         float32       // Fraction of JumpSpeed kept when jump is let go
+	CoyoteTime      time.Duration // How long after walking off a ledge a jump works
+	JumpBuffer      time.Duration // How long before landing a jump press counts
+	StartX          float32
+	StartY          float32
+	dropuntil       time.Time
+	jumpheld        bool
+	jumpuntil       time.Time // A buffered jump press runs out then
+	buffered        bool
+	onground        bool
+	coyoteuntil     time.Time
+	coyote          bool
+	cuttable        bool // Rising from a jump, not a bounce
+	running         bool
+	airjumped       bool // Used the double jump
+	throwheld       bool
+	nextthrow       time.Time
+	clock           Clock
 }
 
 func (s *State) NewPlayer(x float32, y float32) (p *Player) {
-	var (
-		texture = s.system.Textures["darwin-textures"]
-		width   = (texture.Frames[0][1] - texture.Frames[0][0]) * 2
-		height  = texture.Height * 2
-		starty  = y - float32(height)
-	)
 	a := map[int]*Animation{
-		PLAYER_STOPPED | FACING_LEFT:  Anim([]int{4, 5}, 400),
-		PLAYER_STOPPED | FACING_RIGHT: Anim([]int{0, 1}, 400),
-		PLAYER_WALKING | FACING_LEFT:  Anim([]int{3, 5}, 80),
-		PLAYER_WALKING | FACING_RIGHT: Anim([]int{0, 2}, 80),
-		PLAYER_JUMPING | FACING_LEFT:  Anim([]int{5}, 80),
-		PLAYER_JUMPING | FACING_RIGHT: Anim([]int{0}, 80),
+		PLAYER_STOPPED | FACING_LEFT:                   Anim([]int{4, 5}, 400),
+		PLAYER_STOPPED | FACING_RIGHT:                  Anim([]int{0, 1}, 400),
+		PLAYER_WALKING | FACING_LEFT:                   Anim([]int{3, 5}, 160),
+		PLAYER_WALKING | FACING_RIGHT:                  Anim([]int{0, 2}, 160),
+		PLAYER_WALKING | PLAYER_RUNNING | FACING_LEFT:  Anim([]int{3, 5}, 80),
+		PLAYER_WALKING | PLAYER_RUNNING | FACING_RIGHT: Anim([]int{0, 2}, 80),
+		PLAYER_JUMPING | FACING_LEFT:                   Anim([]int{5}, 80),
+		PLAYER_JUMPING | FACING_RIGHT:                  Anim([]int{0}, 80),
 	}
 	p = &Player{
-		Sprite:       s.system.NewSprite("darwin-textures", x, starty, width, height, PLAYER),
-		State:        PLAYER_STOPPED | FACING_RIGHT,
-		LastState:    PLAYER_STOPPED | FACING_RIGHT,
-		NextFrame:    time.Now(),
-		Animations:   a,
-		StartX:       x,
-		StartY:       y,
-		FrameCounter: 0,
-		JumpSpeed:    1.2,
-		WalkSpeed:    0.03,
-		RunSpeed:     0.6,
-		Acceleration: 0.001,
-		Deceleration: 0.001,
-		invincible:   false,
-	}
-	p.Sprite.SetZ(1)
-	p.Sprite.SetFrame(0)
+		Actor:           s.NewActor("darwin-textures", x, y, PLAYER),
+		StartX:          x,
+		StartY:          y,
+		JumpSpeed:       1.2,
+		StartSpeed:      0.03,
+		WalkSpeed:       0.3,
+		RunSpeed:        0.6,
+		Acceleration:    0.001,
+		Deceleration:    0.001,
+		AirDeceleration: 0.0003,
+		SpeedBoost:      1.5,
+		ThrowSpeed:      0.8,
+		ThrowLift:       0.8,
+		ThrowDelay:      time.Duration(250) * time.Millisecond,
+		JumpCut:         0.4,
+		CoyoteTime:      time.Duration(100) * time.Millisecond,
+		JumpBuffer:      time.Duration(120) * time.Millisecond,
+		clock:           s.clock,
+	}
+	p.HurtTime = time.Duration(200) * time.Millisecond
+	p.State = PLAYER_STOPPED | FACING_RIGHT
+	p.LastState = PLAYER_STOPPED | FACING_RIGHT
+	p.Animations = a
+	if p.Body.Sprite != nil {
+		p.Body.Sprite.SetZ(1)
+	}
 	return p
 }
 
-func (p *Player) Invincible() bool {
-	return p.invincible
-}
-
-func (p *Player) SetInvincible() {
-	p.invincible = true
-	p.vincibleat = time.Now().Add(time.Duration(200) * time.Millisecond)
+// Moves the point the player respawns at, given by the floor they stand
+// on there.
+func (p *Player) SetStart(x float32, y float32) {
+	p.StartX = x
+	p.StartY = y
 }
 
 func (p *Player) Respawn() {
-	p.Sprite.Collide = true
-	p.Sprite.VelocityY = 0
-	p.Sprite.VelocityX = 0
-	p.Sprite.MoveTo(twodee.Pt(p.StartX, p.StartY))
+	p.Body.Collide = true
+	p.Body.VelocityY = 0
+	p.Body.VelocityX = 0
+	p.Body.MoveTo(p.StartX, p.StartY-p.Body.Height)
+	p.Body.SavePosition()
 }
 
 func (p *Player) Die() {
-	p.Sprite.Collide = false
-	p.Sprite.VelocityY = -p.JumpSpeed
-	p.Sprite.VelocityX = 0
+	p.Body.Collide = false
+	p.Body.VelocityY = -p.JumpSpeed
+	p.Body.VelocityX = 0
+}
+
+// Called every step with whether jump is held.  Only a press jumps, and a
+// press is kept for JumpBuffer so one made just before landing still counts.
+// Letting go on the way up cuts the jump short.
+func (p *Player) SetJump(held bool) {
+	now := p.clock.Now()
+	if held && !p.jumpheld {
+		p.buffered = true
+		p.jumpuntil = now.Add(p.JumpBuffer)
+	}
+	if !held && p.jumpheld && p.cuttable && p.Body.VelocityY < 0 {
+		p.Body.VelocityY = Max(p.Body.VelocityY, -p.JumpSpeed*p.JumpCut)
+		p.cuttable = false
+	}
+	p.jumpheld = held
+	if !p.buffered || now.After(p.jumpuntil) {
+		return
+	}
+	switch {
+	case p.CanJump():
+		p.Jump()
+	case p.HasPowerup(POWERUP_DOUBLEJUMP) && !p.airjumped && p.Body.Collide:
+		p.Jump()
+		p.airjumped = true
+	}
+}
+
+// The player can jump from the ground, or for CoyoteTime after walking off
+// it.
+func (p *Player) CanJump() bool {
+	return p.onground || (p.coyote && !p.clock.Now().After(p.coyoteuntil))
 }
 
 func (p *Player) Jump() {
+	p.Body.VelocityY = -p.JumpSpeed
+	p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING | PLAYER_RUNNING)
+	p.State |= (PLAYER_JUMPING)
+	p.onground = false
+	p.coyote = false
+	p.buffered = false
+	p.cuttable = true
+}
+
+// Drops down through any platform the player is standing on.
+func (p *Player) Drop() {
 	if p.State&PLAYER_JUMPING != PLAYER_JUMPING {
-		p.Sprite.VelocityY = -p.JumpSpeed
-		p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING)
-		p.State |= (PLAYER_JUMPING)
+		p.Body.Drop = true
+		p.dropuntil = p.clock.Now().Add(time.Duration(200) * time.Millisecond)
+	}
+}
+
+// Called every step with whether throw is held.  Returns true if the player
+// should throw, which they can once per press and no faster than
+// ThrowDelay.
+func (p *Player) SetThrow(held bool) bool {
+	pressed := held && !p.throwheld
+	p.throwheld = held
+	if !pressed || !p.Body.Collide {
+		return false
+	}
+	if p.clock.Now().Before(p.nextthrow) {
+		return false
+	}
+	p.nextthrow = p.clock.Now().Add(p.ThrowDelay)
+	return true
+}
+
+// Called every step with whether run is held.
+func (p *Player) SetRun(held bool) {
+	p.running = held
+}
+
+// Speeds up towards walking or running speed in direction dir, or slows
+// down to it when going faster.  Speed is never lost in the air, so a
+// running jump carries on at running speed.
+func (p *Player) Accelerate(dir float32, ms float32) {
+	var (
+		top = p.WalkSpeed
+		v   = p.Body.VelocityX * dir
+	)
+	if p.running {
+		top = p.RunSpeed
+	}
+	if p.HasPowerup(POWERUP_SPEED) {
+		top *= p.SpeedBoost
+	}
+	switch {
+	case v < top:
+		v = Min(top, Max(p.StartSpeed, v+p.Acceleration*ms))
+	case p.State&PLAYER_JUMPING == 0:
+		v = Max(top, v-p.Deceleration*ms)
+	}
+	p.Body.VelocityX = v * dir
+	p.State |= PLAYER_WALKING
+	if p.running {
+		p.State |= PLAYER_RUNNING
+	} else {
+		p.State &= 511 ^ PLAYER_RUNNING
 	}
 }
 
 func (p *Player) Left(ms float32) {
-	var v = p.Sprite.VelocityX - p.Acceleration*ms
-	p.Sprite.VelocityX = Max(-p.RunSpeed, Min(-p.WalkSpeed, v))
 	p.State &= 511 ^ (FACING_RIGHT | PLAYER_STOPPED)
-	p.State |= (FACING_LEFT | PLAYER_WALKING)
+	p.State |= FACING_LEFT
+	p.Accelerate(-1, ms)
 }
 
 func (p *Player) Right(ms float32) {
-	var v = p.Sprite.VelocityX + p.Acceleration*ms
-	p.Sprite.VelocityX = Min(p.RunSpeed, Max(p.WalkSpeed, v))
 	p.State &= 511 ^ (FACING_LEFT | PLAYER_STOPPED)
-	p.State |= (FACING_RIGHT | PLAYER_WALKING)
+	p.State |= FACING_RIGHT
+	p.Accelerate(1, ms)
 }
 
 func (p *Player) Slow(ms float32) {
-	if Abs(p.Sprite.VelocityX) <= p.Deceleration*ms {
-		p.Sprite.VelocityX = 0
-		p.State &= 511 ^ (PLAYER_WALKING)
+	decel := p.Deceleration
+	if p.State&PLAYER_JUMPING != 0 {
+		decel = p.AirDeceleration
+	}
+	if Abs(p.Body.VelocityX) <= decel*ms {
+		p.Body.VelocityX = 0
+		p.State &= 511 ^ (PLAYER_WALKING | PLAYER_RUNNING)
 		p.State |= PLAYER_STOPPED
 	} else {
-		if p.Sprite.VelocityX > 0 {
-			p.Sprite.VelocityX -= p.Deceleration * ms
+		if p.Body.VelocityX > 0 {
+			p.Body.VelocityX -= decel * ms
 		} else {
-			p.Sprite.VelocityX += p.Deceleration * ms
+			p.Body.VelocityX += decel * ms
 		}
 	}
 }
 
 func (p *Player) Rebound(c *Creature) {
-	if c.Sprite.X() >= p.Sprite.X() {
-		p.Sprite.VelocityX = -p.RunSpeed
+	if c.Body.X >= p.Body.X {
+		p.Body.VelocityX = -p.RunSpeed
 	} else {
-		p.Sprite.VelocityX = p.RunSpeed
+		p.Body.VelocityX = p.RunSpeed
 	}
-	if c.Sprite.Y() >= p.Sprite.Y() {
-		p.Sprite.VelocityY = -p.JumpSpeed
+	if c.Body.Y >= p.Body.Y {
+		p.Body.VelocityY = -p.JumpSpeed
 	} else {
-		p.Sprite.VelocityY = p.JumpSpeed
+		p.Body.VelocityY = p.JumpSpeed
 	}
-	p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING)
+	p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING | PLAYER_RUNNING)
 	p.State |= (PLAYER_JUMPING)
 }
 
 func (p *Player) Bounce(c *Creature) {
-	if c.Sprite.Y() >= p.Sprite.Y() {
-		p.Sprite.VelocityY = -p.JumpSpeed
-		p.Sprite.Move(twodee.Pt(0,-2)) // Clear collision zone
+	if c.Body.Y >= p.Body.Y {
+		p.Body.VelocityY = -p.JumpSpeed
+		p.Body.Move(0, -2) // Clear collision zone
 	} else {
-		p.Sprite.VelocityY = p.JumpSpeed
-		p.Sprite.Move(twodee.Pt(0,2)) // Clear collision zone
+		p.Body.VelocityY = p.JumpSpeed
+		p.Body.Move(0, 2) // Clear collision zone
 	}
-	p.Sprite.VelocityX = 0
-	p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING)
+	p.Body.VelocityX = 0
+	p.State &= 511 ^ (PLAYER_STOPPED | PLAYER_WALKING | PLAYER_RUNNING)
 	p.State |= (PLAYER_JUMPING)
 
 }
@@ -318,18 +474,17 @@ func (p *Player) Bounce(c *Creature) {
 func (p *Player) Update(result int, ms float32) {
 	if result&HITBOTTOM == HITBOTTOM {
 		p.State &= 511 ^ (PLAYER_JUMPING)
+		p.onground = true
+		p.cuttable = false
+		p.airjumped = false
+	} else if p.onground {
+		p.onground = false
+		p.coyote = p.State&PLAYER_JUMPING == 0
+		p.coyoteuntil = p.clock.Now().Add(p.CoyoteTime)
 	}
-	if time.Now().After(p.NextFrame) || p.LastState != p.State {
-		if anim, ok := p.Animations[p.State]; ok {
-			i := p.FrameCounter % anim.Len()
-			p.Sprite.SetFrame(anim.Frames[i])
-			p.NextFrame = time.Now().Add(anim.Duration)
-		}
-		p.FrameCounter = (p.FrameCounter + 1) % 1000
-		p.LastState = p.State
-	}
-	if p.invincible && time.Now().After(p.vincibleat) {
-		p.invincible = false
+	p.Actor.Update(result, ms)
+	if p.Body.Drop && p.clock.Now().After(p.dropuntil) {
+		p.Body.Drop = false
 	}
 }
 
@@ -338,72 +493,74 @@ const (
 	SMALL_MUSHROOM
 )
 
+const (
+	CREATURE_DEATH_HOP = 0.8 // Speed a dead creature is knocked up at
+)
+
 type Creature struct {
-	Sprite       *twodee.Sprite
-	Type         int
-	Points       int
-	State        int
-	LastState    int
-	Speed        float32
-	JumpSpeed    float32
-	NextFrame    time.Time
-	FrameCounter int
-	Animations   map[int]*Animation
-	LastSpawn    time.Time
+	Actor
+	Type      int
+	Speed     float32
+	JumpSpeed float32
+	LastSpawn time.Time
 }
 
+// Creates a creature standing on y.  Once hurt it flashes, and can't be
+// hurt again for HurtTime.
 func (s *State) NewCreature(t string, x float32, y float32, z int) (c *Creature) {
-	var (
-		texture = s.system.Textures[t]
-		width   = (texture.Frames[0][1] - texture.Frames[0][0]) * 2
-		height  = texture.Height * 2
-		starty  = y - float32(height)
-	)
-	a := map[int]*Animation{}
 	c = &Creature{
-		Sprite:       s.system.NewSprite(t, x, starty, width, height, BADGUY),
-		Type:         z,
-		State:        FACING_LEFT,
-		LastState:    FACING_RIGHT,
-		NextFrame:    time.Now(),
-		LastSpawn:    time.Now(),
-		Animations:   a,
-		FrameCounter: 0,
-		JumpSpeed:    0.8,
-		Speed:        0.05,
-		Points:       5,
-	}
-	c.Sprite.SetFrame(0)
-	c.Sprite.VelocityX = -c.Speed
+		Actor:     s.NewActor(t, x, y, BADGUY),
+		Type:      z,
+		LastSpawn: s.clock.Now(),
+		JumpSpeed: 0.8,
+		Speed:     0.05,
+	}
+	c.State = FACING_LEFT
+	c.LastState = FACING_RIGHT
+	c.Points = 5
+	c.HurtTime = time.Duration(400) * time.Millisecond
+	c.HurtState = CREATURE_HURT
+	c.Think = c.Walk
+	c.Body.VelocityX = -c.Speed
 	return
 }
 
-func (c *Creature) Update(result int, ms float32) {
-	if time.Now().After(c.NextFrame) || c.LastState != c.State {
-		if anim, ok := c.Animations[c.State]; ok {
-			i := c.FrameCounter % anim.Len()
-			c.Sprite.SetFrame(anim.Frames[i])
-			c.NextFrame = time.Now().Add(anim.Duration)
-		}
-		c.FrameCounter = (c.FrameCounter + 1) % 1000
-		c.LastState = c.State
+// Knocks the creature off the level.  It flips over, hops and falls through
+// everything below it.
+func (c *Creature) Die() {
+	c.Body.Collide = false
+	c.Body.VelocityX = 0
+	c.Body.VelocityY = -CREATURE_DEATH_HOP
+	c.invincible = false
+	c.State &= 511 ^ CREATURE_HURT
+	c.State |= CREATURE_DYING
+}
+
+func (c *Creature) Dying() bool {
+	return c.State&CREATURE_DYING == CREATURE_DYING
+}
+
+// Walks the creature back and forth, turning at walls.
+func (c *Creature) Walk(result int, ms float32) {
+	if c.Dying() {
+		return
 	}
 	switch {
 	case result&HITRIGHT == HITRIGHT:
 		c.State &= 511 ^ (FACING_RIGHT)
 		c.State |= (FACING_LEFT)
-		c.Sprite.VelocityX = -c.Speed
+		c.Body.VelocityX = -c.Speed
 	case result&HITLEFT == HITLEFT:
 		c.State &= 511 ^ (FACING_LEFT)
 		c.State |= (FACING_RIGHT)
-		c.Sprite.VelocityX = c.Speed
+		c.Body.VelocityX = c.Speed
 	}
-	if diff := Abs(c.Sprite.VelocityX) - c.Speed; diff != 0 {
+	if diff := Abs(c.Body.VelocityX) - c.Speed; diff != 0 {
 		damp := diff / 10
-		if c.Sprite.VelocityX > 0 {
-			c.Sprite.VelocityX -= damp
+		if c.Body.VelocityX > 0 {
+			c.Body.VelocityX -= damp
 		} else {
-			c.Sprite.VelocityX += damp
+			c.Body.VelocityX += damp
 		}
 	}
 }
@@ -413,9 +570,14 @@ func (s *State) NewMushroom(x float32, y float32) *Creature {
 	c.Speed = 0.05
 	c.JumpSpeed = 0.1
 	c.Points = 100
+	c.HP = 3
 	c.Animations = map[int]*Animation{
-		FACING_LEFT:  Anim([]int{0, 1}, 120),
-		FACING_RIGHT: Anim([]int{2, 3}, 120),
+		FACING_LEFT:                   Anim([]int{0, 1}, 120),
+		FACING_RIGHT:                  Anim([]int{2, 3}, 120),
+		FACING_LEFT | CREATURE_HURT:   Anim([]int{4, 0}, 60),
+		FACING_RIGHT | CREATURE_HURT:  Anim([]int{5, 2}, 60),
+		FACING_LEFT | CREATURE_DYING:  Anim([]int{6}, 1000),
+		FACING_RIGHT | CREATURE_DYING: Anim([]int{7}, 1000),
 	}
 	return c
 }
@@ -426,58 +588,143 @@ func (s *State) NewSmallMushroom(x float32, y float32) *Creature {
 	c.JumpSpeed = 0.3
 	c.Points = 250
 	c.Animations = map[int]*Animation{
-		FACING_LEFT:  Anim([]int{0, 1}, 120),
-		FACING_RIGHT: Anim([]int{2, 3}, 120),
+		FACING_LEFT:                   Anim([]int{0, 1}, 120),
+		FACING_RIGHT:                  Anim([]int{2, 3}, 120),
+		FACING_LEFT | CREATURE_HURT:   Anim([]int{4, 0}, 60),
+		FACING_RIGHT | CREATURE_HURT:  Anim([]int{5, 2}, 60),
+		FACING_LEFT | CREATURE_DYING:  Anim([]int{6}, 1000),
+		FACING_RIGHT | CREATURE_DYING: Anim([]int{7}, 1000),
 	}
 	return c
 }
 
 type State struct {
-	system     *twodee.System
-	scene      *twodee.Scene
-	hud        *twodee.Scene
-	textscore  *twodee.Text
-	textfps    *twodee.Text
-	env        *twodee.Env
-	window     *twodee.Window
-	player     *Player
-	livesbar   *LivesBar
-	healthbar  *LivesBar
-	running    bool
-	Victory    bool
-	score      int
-	nextlife   int
-	boundaries []*twodee.Sprite
-	creatures  []*Creature
-	screenxmin float32
-	screenxmax float32
-	screenymin float32
-	screenymax float32
+	system      *twodee.System
+	scene       *twodee.Scene
+	hud         *twodee.Scene
+	textscore   *twodee.Text
+	textpowers  *twodee.Text
+	textfps     *twodee.Text
+	env         *twodee.Env
+	window      *twodee.Window
+	textures    map[string]*twodee.Texture
+	clock       Clock
+	rand        *rand.Rand
+	seed        int64
+	keys        map[int]int
+	level       *Level
+	recorder    *Recorder
+	replay      *Replay
+	player      *Player
+	starts      int // START blocks in the map
+	livesbar    *LivesBar
+	healthbar   *LivesBar
+	running     bool
+	Victory     bool
+	Restarting  bool // Ended to play the level again
+	Quit        bool // Ended to leave the game
+	speed       float32
+	bindings    *Bindings
+	joystick    Joystick // nil for none
+	score       int
+	nextlife    int
+	boundaries  *Grid
+	nearby      []*Body
+	entities    *Entities
+	pool        []*Projectile // Every projectile, in the air or not
+	popups      []*Popup
+	goals       []*Body
+	checkpoints []*Checkpoint
+	checkpoint  *Checkpoint
+	ending      bool
+	endstart    time.Time
+	bonus       int
+	tallied     int
+	width       float32
+	height      float32
+	blockwidth  float32
+	blockheight float32
+	viewwidth   float32
+	viewheight  float32
+	screenx     float32
+	screeny     float32
+	lastscreenx float32
+	lastscreeny float32
+	screenxmin  float32
+	screenxmax  float32
+	screenymin  float32
+	screenymax  float32
 }
 
-func (s *State) KillCreature(c *Creature) {
-	for i, d := range s.creatures {
-		if d == c {
-			s.creatures = append(s.creatures[:i], s.creatures[i+1:]...)
-			break
-		}
+// Creates a Body the size of one frame of the named texture.  It is only
+// given a sprite when there is a system to draw it with.
+func (s *State) NewBody(texture string, x float32, y float32, w int, h int, t int) *Body {
+	b := NewBody(x, y, float32(w), float32(h))
+	if s.system != nil {
+		b.Sprite = s.system.NewSprite(texture, x, y, w, h, t)
 	}
-	s.env.RemoveChild(c.Sprite)
-	return
+	return b
+}
+
+func (s *State) AddBody(b *Body) {
+	if b.Sprite != nil {
+		s.env.AddChild(b.Sprite)
+	}
+}
+
+func (s *State) RemoveBody(b *Body) {
+	if b.Sprite != nil {
+		s.env.RemoveChild(b.Sprite)
+	}
+}
 
+// Kills a creature.  It's only despawned once it has fallen out of view.
+func (s *State) KillCreature(c *Creature) {
+	c.Die()
+}
+
+// Hurts a creature, killing it and scoring its points if that was the last
+// of its HP.  Returns false if the creature couldn't be hurt just then.
+func (s *State) HurtCreature(c *Creature, damage int) bool {
+	if c.Invincible() || c.Dying() {
+		return false
+	}
+	if c.Hurt(damage) <= 0 {
+		s.SetScore(s.Score() + c.Points)
+		s.ShowPopup(c.Body.X+c.Body.Width/2, c.Body.Y, fmt.Sprintf("%v", c.Points))
+		s.KillCreature(c)
+	}
+	return true
 }
 
 func (s *State) SetMaxHealth(health int) {
-	s.healthbar.SetMax(health)
+	s.player.SetMaxHP(health)
+	s.ShowHealth()
 }
 
+// Heals the player, or hurts them if change is negative, and returns their
+// HP.  Either way they can't be hurt again for a moment.
 func (s *State) ChangeHealth(change int) int {
-	if change < 0 && s.player.Invincible() {
-		return s.healthbar.Available()
+	var p = s.player
+	switch {
+	case change < 0 && p.Invincible():
+	case change < 0 && s.HurtPowerups():
+		p.SetInvincible()
+	case change < 0:
+		p.Hurt(-change)
+		p.SetInvincible()
+	default:
+		p.Heal(change)
+		p.SetInvincible()
 	}
-	s.player.SetInvincible()
-	var health = s.healthbar.SetAvailable(s.healthbar.Available() + change)
-	return health
+	s.ShowHealth()
+	return p.HP
+}
+
+// The health bar shows the player's HP.
+func (s *State) ShowHealth() {
+	s.healthbar.SetMax(s.player.MaxHP)
+	s.healthbar.SetAvailable(s.player.HP)
 }
 
 func (s *State) ChangeMaxLives(i int) {
@@ -485,7 +732,15 @@ func (s *State) ChangeMaxLives(i int) {
 }
 
 func (s *State) ChangeLives(i int) int {
-	var lives = s.livesbar.SetAvailable(s.livesbar.Available() + i)
+	var (
+		before = s.livesbar.Available()
+		lives  = s.livesbar.SetAvailable(before + i)
+	)
+	if i < 0 {
+		s.LosePowerup(s.player.Powerups)
+	} else if i > 0 {
+		s.ClimbPowerupLadder(before, lives)
+	}
 	if lives == 0 {
 		s.running = false
 	}
@@ -494,8 +749,10 @@ func (s *State) ChangeLives(i int) int {
 
 func (s *State) SetScore(score int) {
 	s.score = score
-	s.textscore.SetText(fmt.Sprintf("%v", s.score))
-	s.textscore.MoveTo(twodee.Pt(s.window.View.Max.X-s.textscore.Width(), 0))
+	if s.textscore != nil {
+		s.textscore.SetText(fmt.Sprintf("%v", s.score))
+		s.textscore.MoveTo(twodee.Pt(s.window.View.Max.X-s.textscore.Width(), 0))
+	}
 	if s.score >= s.nextlife {
 		s.ChangeMaxLives(1)
 		s.ChangeLives(1)
@@ -507,108 +764,312 @@ func (s *State) Score() int {
 	return s.score
 }
 
-func (s *State) HandleKeys(key, state int) {
-	switch key {
-	case twodee.KeyEsc:
-		s.running = false
+// Reseeds the random source used for everything in the game, so the same
+// seed and input always play out the same way.
+func (s *State) SetSeed(seed int64) {
+	s.seed = seed
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+func (s *State) Seed() int64 {
+	return s.seed
+}
+
+// Starts writing every step's input to path.
+func (s *State) Record(path string) (err error) {
+	s.recorder, err = NewRecorder(path, s.seed, STEP_MS, s.level.Path)
+	return
+}
+
+// Plays input back from a recording instead of the keyboard.  The game
+// stops when the recording runs out.
+func (s *State) Replay(replay *Replay) (err error) {
+	if err = replay.Check(s.level.Path, STEP_MS); err != nil {
+		return
+	}
+	s.SetSeed(replay.Seed)
+	s.replay = replay
+	return
+}
+
+// Finishes any recording or replay.
+func (s *State) Close() (err error) {
+	if s.replay != nil {
+		s.replay.Close()
+		s.replay = nil
+	}
+	if s.recorder != nil {
+		err = s.recorder.Close()
+		s.recorder = nil
 	}
+	return
+}
+
+func (s *State) SetBindings(b *Bindings) {
+	s.bindings = b
+}
+
+// A speed of 0.5 runs the game at half speed.
+func (s *State) SetSpeed(speed float32) {
+	s.speed = speed
+}
+
+func (s *State) Speed() float32 {
+	return s.speed
+}
+
+func (s *State) Key(key int) int {
+	if s.system == nil {
+		return s.keys[key]
+	}
+	return s.system.Key(key)
+}
+
+// Returns the input for this step, read from the replay if there is one and
+// recorded if recording.
+func (s *State) PollInput() (input int) {
+	if s.replay != nil {
+		var ok bool
+		if input, ok = s.replay.Next(); !ok {
+			s.running = false
+		}
+	} else {
+		for _, a := range Actions {
+			for _, key := range s.bindings.Keys[a.Input] {
+				if s.Key(key) == 1 {
+					input |= a.Input
+				}
+			}
+		}
+		input |= JoystickInput(s.joystick, s.bindings)
+		input &^= INPUT_PAUSE
+	}
+	if s.recorder != nil {
+		s.recorder.Record(input)
+	}
+	return
 }
 
 func (s *State) CheckKeys(ms float32) {
-	switch {
-	case s.system.Key(twodee.KeyUp) == 1 && s.system.Key(twodee.KeyDown) == 0:
-		s.player.Jump()
-	case s.system.Key(twodee.KeyUp) == 0 && s.system.Key(twodee.KeyDown) == 1:
-		//s.char.VelocityY = speed
+	input := s.PollInput()
+	if s.ending {
+		// The player walks off on their own
+		input = INPUT_RIGHT
 	}
-	switch {
-	case s.system.Key(twodee.KeyLeft) == 1 && s.system.Key(twodee.KeyRight) == 0:
+	s.player.SetRun(input&INPUT_RUN != 0)
+	if s.player.SetThrow(input&INPUT_THROW != 0) {
+		s.PlayerThrow()
+	}
+	s.player.SetJump(input&(INPUT_JUMP|INPUT_DOWN) == INPUT_JUMP)
+	if input&(INPUT_JUMP|INPUT_DOWN) == INPUT_DOWN {
+		s.player.Drop()
+	}
+	switch input & (INPUT_LEFT | INPUT_RIGHT) {
+	case INPUT_LEFT:
 		s.player.Left(ms)
-	case s.system.Key(twodee.KeyLeft) == 0 && s.system.Key(twodee.KeyRight) == 1:
+	case INPUT_RIGHT:
 		s.player.Right(ms)
 	default:
 		s.player.Slow(ms)
 	}
 }
 
-func (s *State) Visible(sprite *twodee.Sprite) bool {
+func (s *State) Visible(body *Body) bool {
 	var (
-		wb     = s.window.View.Sub(s.env.Bounds().Min)
-		sb     = sprite.RelativeBounds(s.env)
+		wb     = Rect{-s.screenx, -s.screeny, s.viewwidth - s.screenx, s.viewheight - s.screeny}
+		sb     = body.Bounds()
 		buffer = float32(256)
 	)
-	wb.Min.X -= buffer
-	wb.Min.Y -= buffer
-	wb.Max.X += buffer
-	wb.Max.X += buffer
+	wb.MinX -= buffer
+	wb.MinY -= buffer
+	wb.MaxX += buffer
+	wb.MaxX += buffer
 	return sb.Overlaps(wb)
 }
 
-func (s *State) UpdateSprite(sprite *twodee.Sprite, ms float32) (result int) {
-	sprite.VelocityY += 0.005 * ms // Gravity
+func (s *State) UpdateSprite(sprite *Body, ms float32) (result int) {
+	if !sprite.Floating {
+		sprite.VelocityY += 0.005 * ms // Gravity
+	}
 	var (
 		dX = sprite.VelocityX * ms
 		dY = sprite.VelocityY * ms
-		b  = sprite.RelativeBounds(s.env)
+		b  = sprite.Bounds()
 	)
-	if b.Min.X+dX < 0 {
+	if b.MinX+dX < 0 {
 		result |= HITLEFT
 		sprite.VelocityX = 0
-		sprite.Move(twodee.Pt(1, 0))
+		sprite.Move(1, 0)
 		dX = 0
 	}
-	if b.Max.X+dX > s.env.Width() {
-		/*
-			fmt.Printf("HITRIGHT\n")
-			fmt.Printf("sprite.RelativeBounds(s.env) %v\n", sprite.RelativeBounds(s.env))
-			fmt.Printf("sprite.LocalBounds() %v\n", sprite.Bounds())
-		*/
+	if b.MaxX+dX > s.width {
 		result |= HITRIGHT
 		sprite.VelocityX = 0
-		sprite.Move(twodee.Pt(-1, 0))
+		sprite.Move(-1, 0)
 		dX = 0
 	}
 	if sprite.Collide {
-		for _, block := range s.boundaries {
-			if dX != 0 && !sprite.TestMove(dX, 0, block) {
-				if sprite.TestMove(dX, -block.Height(), block) {
-					// Allows running up small bumps
-					sprite.Move(twodee.Pt(0, -block.Height()))
+		// Look a block further out than the move, since running up a bump
+		// can shift the sprite by a block height.
+		area := Rect{
+			Min(b.MinX, b.MinX+dX) - s.blockwidth,
+			Min(b.MinY, b.MinY+dY) - 2*s.blockheight,
+			Max(b.MaxX, b.MaxX+dX) + s.blockwidth,
+			Max(b.MaxY, b.MaxY+dY) + s.blockheight,
+		}
+		s.nearby = s.boundaries.Query(area, s.nearby[:0])
+		// Sweep each axis in turn, cutting the move short at the nearest
+		// block so nothing gets skipped however fast the sprite goes.
+		if block, _, nx, _ := s.FirstHit(sprite, dX, 0, s.SlopeFloor(sprite)); block != nil {
+			// Step up onto anything no more than a block above the sprite's
+			// feet, which also carries sprites off the top of slopes.
+			rise := sprite.Y + sprite.Height - block.Y
+			if rise > 0 && rise <= block.Height {
+				sprite.Move(0, -rise)
+				if hit, _, _, _ := s.FirstHit(sprite, dX, 0, s.SlopeFloor(sprite)); hit != nil {
+					sprite.Move(0, rise)
 				} else {
-					if dX < 0 {
-						sprite.MoveTo(twodee.Pt(block.X()+block.Width(), sprite.Y()))
-						result |= HITLEFT
-					} else {
-						sprite.MoveTo(twodee.Pt(block.X()-sprite.Width(), sprite.Y()))
-						result |= HITRIGHT
-					}
-					sprite.VelocityX = 0
-					dX = 0
+					block = nil
 				}
 			}
-			if dY != 0 && !sprite.TestMove(0, dY, block) {
-				if dY < 0 {
-					sprite.MoveTo(twodee.Pt(sprite.X(), block.Y()+block.Height()))
-					result |= HITTOP
+			if block != nil {
+				if nx > 0 {
+					dX = block.X + block.Width - sprite.X
+					result |= HITLEFT
 				} else {
-					sprite.MoveTo(twodee.Pt(sprite.X(), block.Y()-sprite.Height()))
-					result |= HITBOTTOM
+					dX = block.X - sprite.Width - sprite.X
+					result |= HITRIGHT
 				}
+				sprite.VelocityX = 0
+			}
+		}
+		sprite.Move(dX, 0)
+		dX = 0
+		if block, _, _, ny := s.FirstHit(sprite, 0, dY, s.SlopeFloor(sprite)); block != nil {
+			if ny > 0 {
+				dY = block.Y + block.Height - sprite.Y
+				result |= HITTOP
+			} else {
+				dY = block.Y - sprite.Height - sprite.Y
+				result |= HITBOTTOM
+			}
+			sprite.VelocityY = 0
+		}
+		sprite.Move(0, dY)
+		result |= s.SnapToSlope(sprite, dY)
+		dY = 0
+		if sprite.Grounded && result&HITBOTTOM == 0 && sprite.VelocityY >= 0 {
+			// Follow the ground down off the bottom of a slope
+			if block, _, _, _ := s.FirstHit(sprite, 0, s.SlopeReach(sprite), s.SlopeFloor(sprite)); block != nil {
+				sprite.Move(0, block.Y-sprite.Y-sprite.Height)
 				sprite.VelocityY = 0
-				dY = 0
+				result |= HITBOTTOM
 			}
 		}
 	}
 	if dX != 0 || dY != 0 {
-		sprite.Move(twodee.Pt(dX, dY))
+		sprite.Move(dX, dY)
+	}
+	sprite.Grounded = result&HITBOTTOM == HITBOTTOM
+	return
+}
+
+// How far a sprite's feet can be from a slope's surface and still be on it.
+// Half the sprite's width covers the gap between the surface under its middle
+// and the top of the block its edge is over.
+func (s *State) SlopeReach(sprite *Body) float32 {
+	return Max(s.blockheight/2, sprite.Width/2)
+}
+
+// While the middle of a sprite is on a slope, the blocks either side of the
+// slope are overlapped by the sprite's edges.  Returns the height below
+// which blocks should count as ground to be left to the slope, or +Inf if
+// the sprite isn't on one.
+func (s *State) SlopeFloor(sprite *Body) float32 {
+	var (
+		x      = sprite.X + sprite.Width/2
+		bottom = sprite.Y + sprite.Height
+		reach  = s.SlopeReach(sprite)
+	)
+	for _, block := range s.nearby {
+		if surface, ok := block.Surface(x); ok && Abs(bottom-surface) <= reach {
+			return bottom - reach - 1
+		}
+	}
+	return float32(math.Inf(1))
+}
+
+// Keeps a sprite's feet on any slope under the middle of it.  Sprites coming
+// down onto a slope land on it, and ones already on the ground follow it
+// down instead of bouncing off.  dy is how far the sprite just fell.
+func (s *State) SnapToSlope(sprite *Body, dy float32) (result int) {
+	if sprite.VelocityY < 0 {
+		return
+	}
+	var (
+		x      = sprite.X + sprite.Width/2
+		bottom = sprite.Y + sprite.Height
+		reach  = s.SlopeReach(sprite)
+		found  = false
+		ground float32
+	)
+	for _, block := range s.nearby {
+		surface, ok := block.Surface(x)
+		if !ok {
+			continue
+		}
+		var (
+			landing   = bottom >= surface && bottom-dy-surface <= reach
+			following = sprite.Grounded && surface > bottom && surface-bottom <= reach
+		)
+		if (landing || following) && (!found || surface < ground) {
+			found = true
+			ground = surface
+		}
+	}
+	if found {
+		sprite.Move(0, ground-bottom)
+		sprite.VelocityY = 0
+		result |= HITBOTTOM
+	}
+	return
+}
+
+// Returns the nearby block that sprite would run into first moving by dx,
+// dy, with the time of impact and contact normal from Body.Sweep.  Landing
+// on slopes is left to SnapToSlope, and so are blocks with tops below
+// floor, which comes from SlopeFloor.  Only the parts of slopes from
+// Body.SlopeSide are hit here.
+func (s *State) FirstHit(sprite *Body, dx float32, dy float32, floor float32) (block *Body, t float32, nx float32, ny float32) {
+	if dx == 0 && dy == 0 {
+		return
+	}
+	t = 1
+	for _, b := range s.nearby {
+		if sprite.Drop && b.Solid != SIDE_ALL {
+			continue
+		}
+		if b.Slope {
+			if _, over := b.Surface(sprite.X + sprite.Width/2); over && dy > 0 {
+				continue
+			}
+			if b = b.SlopeSide(dx, dy); b == nil {
+				continue
+			}
+		}
+		if b.Y >= floor {
+			continue
+		}
+		if bt, bnx, bny, hit := sprite.Sweep(dx, dy, b); hit && (block == nil || bt < t) {
+			block, t, nx, ny = b, bt, bnx, bny
+		}
 	}
-	//sprite.MoveTo(twodee.Pt(Round(sprite.X()), Round(sprite.Y())))
 	return
 }
 
 func (s *State) IsKillShot(c *Creature) bool {
 	var (
-		downward = s.player.Sprite.VelocityY > 0.1
+		downward = s.player.Body.VelocityY > 0.1
 		//jumping = s.player.State&PLAYER_JUMPING == PLAYER_JUMPING
 	)
 	//return downward && jumping
@@ -616,18 +1077,18 @@ func (s *State) IsKillShot(c *Creature) bool {
 }
 
 func (s *State) Update(ms float32) {
-	if DEBUG {
-		s.textfps.SetText(fmt.Sprintf("FPS %-5.1f", (1000.0 / ms)))
-	}
-	for _, c := range s.creatures {
-		if s.player.Sprite.Collide {
-			if s.player.Sprite.CollidesWith(c.Sprite) {
+	for _, c := range s.entities.Creatures {
+		if c.Dying() && !s.Visible(c.Body) {
+			s.entities.Despawn(c)
+			continue
+		}
+		if s.player.Body.Collide && !s.ending && !c.Dying() {
+			if s.player.Body.CollidesWith(c.Body) {
 				if s.IsKillShot(c) {
-					s.SetScore(s.Score() + c.Points)
-					s.KillCreature(c)
+					s.HurtCreature(c, 1)
 					s.player.Bounce(c)
 				} else {
-					health := s.healthbar.Available()
+					health := s.player.HP
 					if !s.player.Invincible() {
 						s.player.Rebound(c)
 						health = s.ChangeHealth(-1)
@@ -638,103 +1099,231 @@ func (s *State) Update(ms float32) {
 				}
 			}
 		}
-		if s.Visible(c.Sprite) {
-			result := s.UpdateSprite(c.Sprite, ms)
+		if s.Visible(c.Body) {
+			result := s.UpdateSprite(c.Body, ms)
 			c.Update(result, ms)
+			if c.Dying() {
+				continue
+			}
 			switch c.Type {
 			case MUSHROOM:
 				thresh := time.Duration(5) * time.Second
-				if time.Now().After(c.LastSpawn.Add(thresh)) {
-					if rand.Float32() > 0.95 {
-						c2 := s.NewSmallMushroom(c.Sprite.X(), c.Sprite.Y())
-						c2.Sprite.VelocityX *= rand.Float32() * 2.0
-						if rand.Float32() > 0.5 {
-							c2.Sprite.VelocityX *= -1
+				if s.clock.Now().After(c.LastSpawn.Add(thresh)) {
+					if s.rand.Float32() > 0.95 {
+						c2 := s.NewSmallMushroom(c.Body.X, c.Body.Y)
+						c2.Body.VelocityX *= s.rand.Float32() * 2.0
+						if s.rand.Float32() > 0.5 {
+							c2.Body.VelocityX *= -1
 						}
-						c2.Sprite.VelocityY = -c2.JumpSpeed
-						s.creatures = append(s.creatures, c2)
-						s.env.AddChild(c2.Sprite)
-						c.LastSpawn = time.Now()
+						c2.Body.VelocityY = -c2.JumpSpeed
+						s.entities.Spawn(c2)
+						c.LastSpawn = s.clock.Now()
 					}
 				}
 			}
 		}
 	}
 
-	result := s.UpdateSprite(s.player.Sprite, ms)
+	result := s.UpdateSprite(s.player.Body, ms)
 	s.player.Update(result, ms)
+	s.UpdateProjectiles(ms)
+	s.UpdatePopups(ms)
 
-	var b = s.player.Sprite.RelativeBounds(s.env)
-	if b.Max.Y > s.env.Height()+1000 {
+	var b = s.player.Body.Bounds()
+	if b.MaxY > s.height+1000 && !s.ending {
 		//Player has fallen off the map
 		lives := s.ChangeLives(-1)
 		if lives > 0 {
-			s.ChangeHealth(s.healthbar.Max())
+			s.ChangeHealth(s.player.MaxHP)
 			s.player.Respawn()
 			s.UpdateViewport(0)
 		}
 	}
-	if b.Max.X >= s.env.Width() - 100 {
+	if !s.ending {
+		for _, c := range s.checkpoints {
+			if c != s.checkpoint && s.player.Body.CollidesWith(c.Body) {
+				s.SetCheckpoint(c)
+			}
+		}
+	}
+	if s.player.Body.Collide && !s.ending {
+		for _, p := range s.entities.Pickups {
+			if s.player.Body.CollidesWith(p.Body) {
+				s.Collect(p)
+			}
+		}
+	}
+	switch {
+	case s.ending:
+		s.UpdateEnding()
+	case s.level.Victory == VICTORY_EDGE && b.MaxX >= s.width-100:
 		// Poor man's victory
+		s.EndLevel()
+	case s.level.Victory == VICTORY_GOAL && s.TouchingGoal():
+		s.EndLevel()
+	}
+}
+
+// Checkpoints show their frame until touched, then the frame after it.
+type Checkpoint struct {
+	Body  *Body
+	Frame int
+}
+
+// Makes c where the player respawns, and the only checkpoint that shows as
+// touched.
+func (s *State) SetCheckpoint(c *Checkpoint) {
+	if s.checkpoint != nil {
+		s.checkpoint.Body.SetFrame(s.checkpoint.Frame)
+	}
+	s.checkpoint = c
+	c.Body.SetFrame(c.Frame + 1)
+	s.player.SetStart(c.Body.X, c.Body.Y+c.Body.Height)
+}
+
+func (s *State) TouchingGoal() bool {
+	for _, g := range s.goals {
+		if s.player.Body.CollidesWith(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Starts the end of the level: the player walks off while the health they
+// have left is added to their score.
+func (s *State) EndLevel() {
+	s.ending = true
+	s.endstart = s.clock.Now()
+	s.bonus = s.player.HP * HEALTH_BONUS
+	s.tallied = 0
+}
+
+// Tallies the bonus over END_MS, then ends the level.
+func (s *State) UpdateEnding() {
+	elapsed := float32(s.clock.Now().Sub(s.endstart)) / float32(time.Millisecond)
+	due := int(float32(s.bonus) * Min(elapsed/END_MS, 1))
+	if due > s.tallied {
+		s.SetScore(s.Score() + due - s.tallied)
+		s.tallied = due
+	}
+	if elapsed >= END_MS {
 		s.running = false
 		s.Victory = true
 	}
-
 }
 
+// Moves the view towards the player.  This only tracks where the env should
+// be; Paint is what actually moves it.
 func (s *State) UpdateViewport(ms float32) {
 	var (
-		r  = 0.1 * ms
-		b  = s.env.RelativeBounds(s.player.Sprite)
-		v  = s.window.View
-		x  = Min(Max(b.Min.X+v.Dx()/2, s.screenxmin), s.screenxmax)
-		y  = Min(Max(b.Min.Y+v.Dy()/2-s.player.Sprite.Height()/2, s.screenymin), s.screenymax)
-		dy = y - s.env.Y()
-		dx = x - s.env.X()
-		d  = twodee.Pt(dx/r, dy/r)
+		f  = Follow(CAMERA_FOLLOW, ms)
+		x  = Min(Max(-s.player.Body.X+s.viewwidth/2, s.screenxmin), s.screenxmax)
+		y  = Min(Max(-s.player.Body.Y+s.viewheight/2-s.player.Body.Height/2, s.screenymin), s.screenymax)
+		dy = y - s.screeny
+		dx = x - s.screenx
+		d  = twodee.Pt(dx*f, dy*f)
 	)
-	/*
-		fmt.Printf("Bounds %v\n", s.char.GlobalBounds())
-		fmt.Printf("s.env.RelativeBounds(s.char) %v\n", s.env.RelativeBounds(s.char))
-		fmt.Printf("s.char.RelativeBounds(s.env) %v\n", s.char.RelativeBounds(s.env))
-		fmt.Printf("Moving viewport to %v, %v\n", x, y)
-	*/
-	if s.player.Sprite.Collide {
+	if s.player.Body.Collide {
 		// Only smooth motion if the player isn't dying
 		if ms == 0 || (dy < 1 && dy > -1) {
-			s.env.MoveTo(twodee.Pt(x, y))
+			s.screenx = x
+			s.screeny = y
+			if ms == 0 {
+				s.lastscreenx = x
+				s.lastscreeny = y
+			}
 			return
 		}
+		var (
+			fy  = Follow(CAMERA_FOLLOW_Y, ms)
+			min = CAMERA_MIN_Y * ms
+		)
 		if dy > 0 {
-			d.Y = Max(1, dy/30)
+			d.Y = Min(dy, Max(min, dy*fy))
 		} else {
-			d.Y = Min(-1, dy/30)
+			d.Y = Max(dy, Min(-min, dy*fy))
 		}
 	}
-	s.env.Move(d)
-	s.env.MoveTo(twodee.Pt(Round(s.env.X()), Round(s.env.Y())))
+	// Moves too small to survive rounding would leave the view stuck short
+	if Abs(d.X) < 0.5 {
+		d.X = dx
+	}
+	if Abs(d.Y) < 0.5 {
+		d.Y = dy
+	}
+	s.screenx = Round(s.screenx + d.X)
+	s.screeny = Round(s.screeny + d.Y)
+}
+
+// Advances the game by ms milliseconds of input, physics and viewport.
+func (s *State) Step(ms float32) {
+	s.player.Body.SavePosition()
+	for _, c := range s.entities.Creatures {
+		c.Body.SavePosition()
+	}
+	for _, p := range s.entities.Projectiles {
+		p.Body.SavePosition()
+	}
+	s.lastscreenx = s.screenx
+	s.lastscreeny = s.screeny
+	s.CheckKeys(ms)
+	if !s.running {
+		// The replay ran out
+		return
+	}
+	s.Update(ms)
+	s.entities.Flush()
+	s.UpdateViewport(ms)
+	s.clock.Advance(Ms(ms))
 }
 
 func (s *State) HandleAddBlock(block *twodee.EnvBlock, sprite *twodee.Sprite, x float32, y float32) {
 	switch block.Type {
 	case START:
+		s.starts++
 		s.player = s.NewPlayer(x, y)
-		s.env.AddChild(s.player.Sprite)
+		s.AddBody(s.player.Body)
 		fallthrough
 	case FLOOR:
-		s.boundaries = append(s.boundaries, sprite)
+		s.boundaries.Add(NewBody(x, y, s.blockwidth, s.blockheight))
 	case BADGUY:
-		c := s.NewMushroom(x, y)
-		s.creatures = append(s.creatures, c)
-		s.env.AddChild(c.Sprite)
+		s.entities.Spawn(s.NewMushroom(x, y))
+	case PLATFORM:
+		b := NewBody(x, y, s.blockwidth, s.blockheight)
+		b.Solid = SIDE_TOP
+		s.boundaries.Add(b)
+	case SLOPE_UP, SLOPE_DOWN, SLOPE_UP_LOW, SLOPE_UP_HIGH, SLOPE_DOWN_HIGH, SLOPE_DOWN_LOW:
+		s.boundaries.Add(NewSlope(block.Type, x, y, s.blockwidth, s.blockheight))
+	case GOAL:
+		s.goals = append(s.goals, NewBody(x, y, s.blockwidth, s.blockheight))
+	case CHECKPOINT:
+		b := NewBody(x, y, s.blockwidth, s.blockheight)
+		b.Sprite = sprite
+		s.checkpoints = append(s.checkpoints, &Checkpoint{b, block.FrameIndex})
+	case COIN, HEART, ONEUP, POWERUP:
+		s.entities.Spawn(s.NewPickup(block.Type, x, y))
 	}
 }
 
 func (s *State) Running() bool {
-	return s.running && s.window.Opened()
+	return s.running && (s.window == nil || s.window.Opened())
 }
 
-func (s *State) Paint(ms float32) {
+// Draws the game alpha of the way between the last step and the current
+// one.  ms is only used to show the frame rate.
+func (s *State) Paint(ms float32, alpha float32) {
+	if DEBUG {
+		s.textfps.SetText(fmt.Sprintf("FPS %-5.1f SEED %v", (1000.0 / ms), s.seed))
+	}
+	s.player.Body.Sync(alpha)
+	for _, c := range s.entities.Creatures {
+		c.Body.Sync(alpha)
+	}
+	for _, p := range s.entities.Projectiles {
+		p.Body.Sync(alpha)
+	}
+	s.env.MoveTo(twodee.Pt(Lerp(s.lastscreenx, s.screenx, alpha), Lerp(s.lastscreeny, s.screeny, alpha)))
 	s.system.Paint(s.scene)
 }
 
@@ -743,135 +1332,143 @@ const (
 	START
 	PLAYER
 	BADGUY
+	PLATFORM
+	SLOPE_UP        // 45 degrees, rising to the right
+	SLOPE_DOWN      // 45 degrees, falling to the right
+	SLOPE_UP_LOW    // Half a block per block, about 26.6 degrees, lower half of a rise
+	SLOPE_UP_HIGH   // Half a block per block, upper half of a rise
+	SLOPE_DOWN_HIGH // Half a block per block, upper half of a fall
+	SLOPE_DOWN_LOW  // Half a block per block, lower half of a fall
+	GOAL
+	CHECKPOINT
+	COIN
+	HEART
+	ONEUP
+	POWERUP
 )
 
-type TexInfo struct {
-	Name  string
-	Path  string
-	Width int
+// Surface heights at the left and right of each slope, as a fraction of the
+// block height.
+var Slopes = map[int][2]float32{
+	SLOPE_UP:        {0, 1},
+	SLOPE_DOWN:      {1, 0},
+	SLOPE_UP_LOW:    {0, 0.5},
+	SLOPE_UP_HIGH:   {0.5, 1},
+	SLOPE_DOWN_HIGH: {1, 0.5},
+	SLOPE_DOWN_LOW:  {0.5, 0},
 }
 
-func Init(system *twodee.System, window *twodee.Window) (state *State, err error) {
+// Creates the body for a slope block of type t.
+func NewSlope(t int, x float32, y float32, w float32, h float32) *Body {
+	heights := Slopes[t]
+	b := NewBody(x, y, w, h)
+	b.Slope = true
+	b.SlopeLeft = heights[0]
+	b.SlopeRight = heights[1]
+	return b
+}
+
+// Sets up everything a State needs whether or not it has a window.
+func NewState(clock Clock, viewwidth float32, viewheight float32) (state *State) {
 	state = &State{}
-	state.creatures = make([]*Creature, 0)
-	state.boundaries = make([]*twodee.Sprite, 0)
+	state.clock = clock
+	state.SetSeed(time.Now().UnixNano())
+	state.entities = NewEntities(state.AddBody, state.RemoveBody)
+	state.textures = map[string]*twodee.Texture{}
+	state.keys = map[int]int{}
+	state.speed = 1
+	state.bindings = DefaultBindings()
+	state.viewwidth = viewwidth
+	state.viewheight = viewheight
+	state.livesbar = NewLivesBar(nil, 0, 0)
+	state.healthbar = NewLivesBar(nil, 0, 0)
+	return
+}
+
+// Must be called before the level is loaded, since the boundaries are kept
+// in a grid of blocks.
+func (s *State) SetBlockSize(width int, height int) {
+	s.blockwidth = float32(width)
+	s.blockheight = float32(height)
+	s.boundaries = NewGrid(s.blockwidth, s.blockheight)
+}
+
+// Reads a level manifest and gets the state ready to load its map.  Returns
+// the options to load the map with.
+func (s *State) SetLevel(path string) (opts twodee.EnvOpts, err error) {
+	if s.level, err = LoadLevelManifest(path); err != nil {
+		return
+	}
+	s.SetBlockSize(s.level.BlockWidth, s.level.BlockHeight)
+	opts = s.level.EnvOpts(func(block *twodee.EnvBlock, sprite *twodee.Sprite, x float32, y float32) {
+		s.HandleAddBlock(block, sprite, x, y)
+	})
+	return
+}
+
+// Checks the map just loaded has what the level needs to be played: one
+// START block, for the player, and a GOAL block if that's how it's won.
+func (s *State) CheckMap() error {
+	if s.starts != 1 {
+		return fmt.Errorf("%v: map has %v START blocks, not 1", s.level.Map, s.starts)
+	}
+	if s.level.Victory == VICTORY_GOAL && len(s.goals) == 0 {
+		return fmt.Errorf("%v: map has no GOAL block to win by", s.level.Map)
+	}
+	return nil
+}
+
+// Sets the score, lives and health a new game starts with.
+func (s *State) Start() {
+	// Everything the level spawned as it loaded
+	s.entities.Flush()
+	s.nextlife = 400
+	s.SetScore(0)
+	s.ChangeMaxLives(1)
+	s.ChangeLives(1)
+	s.SetMaxHealth(3)
+	s.ChangeHealth(3)
+	s.running = true
+	s.Victory = false
+	s.ending = false
+	s.screenxmin = -s.width + s.viewwidth
+	s.screenxmax = 0
+	s.screenymin = -s.height + s.viewheight
+	s.screenymax = 0
+}
+
+func Init(system *twodee.System, window *twodee.Window, clock Clock, path string) (state *State, err error) {
+	state = NewState(clock, window.View.Dx(), window.View.Dy())
 	state.hud = &twodee.Scene{}
 	state.scene = &twodee.Scene{}
 	state.env = &twodee.Env{}
 	state.window = window
 	state.system = system
-	textures := []TexInfo{
-		TexInfo{"level-textures", "assets/level-textures.png", 16},
-		TexInfo{"enemy-sm-textures", "assets/enemy-sm-textures-fw.png", 0},
-		TexInfo{"enemy-textures", "assets/enemy-textures-fw.png", 0},
-		TexInfo{"font1-textures", "assets/font1-textures.png", 0},
-		TexInfo{"darwin-textures", "assets/darwin-textures.png", 0},
-		TexInfo{"powerups-textures", "assets/powerups-textures-fw.png", 0},
-	}
-	for _, t := range textures {
-		if err = system.LoadTexture(t.Name, t.Path, twodee.IntNearest, t.Width); err != nil {
-			return
-		}
+	state.joystick = GLFWJoystick{}
+	var opts twodee.EnvOpts
+	if opts, err = state.SetLevel(path); err != nil {
+		return
 	}
-	BlockHandler := func(block *twodee.EnvBlock, sprite *twodee.Sprite, x float32, y float32) {
-		state.HandleAddBlock(block, sprite, x, y)
-	}
-	opts := twodee.EnvOpts{
-		Blocks: []*twodee.EnvBlock{
-			&twodee.EnvBlock{
-				Color:      color.RGBA{153, 102, 0, 255}, // Dirt
-				Type:       FLOOR,
-				FrameIndex: 0,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{0, 204, 51, 255}, // Green top
-				Type:       FLOOR,
-				FrameIndex: 1,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{51, 102, 0, 255}, // Top left corner
-				Type:       FLOOR,
-				FrameIndex: 2,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{51, 153, 0, 255}, // Top right corner
-				Type:       FLOOR,
-				FrameIndex: 3,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{153, 153, 51, 255}, // Left dirt wall
-				Type:       FLOOR,
-				FrameIndex: 4,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{153, 153, 102, 255}, // Right dirt wall
-				Type:       FLOOR,
-				FrameIndex: 5,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{204, 204, 51, 255}, // Left grass cap
-				Type:       FLOOR,
-				FrameIndex: 6,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{204, 204, 102, 255}, // Right grass cap
-				Type:       FLOOR,
-				FrameIndex: 7,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{153, 153, 153, 255}, // Rock
-				Type:       FLOOR,
-				FrameIndex: 8,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{118, 118, 118, 255}, // Rock left
-				Type:       FLOOR,
-				FrameIndex: 9,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{84, 84, 84, 255}, // Rock right
-				Type:       FLOOR,
-				FrameIndex: 10,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{0, 0, 0, 255},
-				Type:       START,
-				FrameIndex: 1,
-				Handler:    BlockHandler,
-			},
-			&twodee.EnvBlock{
-				Color:      color.RGBA{51, 51, 51, 255},
-				Type:       BADGUY,
-				FrameIndex: -1,
-				Handler:    BlockHandler,
-			},
-		},
-		TextureName: "level-textures",
-		MapPath:     "assets/level-fw.png",
-		BlockWidth:  32,
-		BlockHeight: 32,
+	if err = LoadTextures(system, state.level.Textures); err != nil {
+		return
 	}
+	state.textures = system.Textures
 	if err = state.env.Load(system, opts); err != nil {
 		return
 	}
-	state.system.SetClearColor(102, 204, 255, 255)
+	if err = state.CheckMap(); err != nil {
+		return
+	}
+	state.width = state.env.Width()
+	state.height = state.env.Height()
+	c := state.level.ClearColor
+	state.system.SetClearColor(c[0], c[1], c[2], c[3])
+	if state.level.Background != "" {
+		// Added first so it's drawn behind the level, and never moved
+		bg := system.NewSprite(state.level.Background, 0, 0, int(window.View.Dx()), int(window.View.Dy()), 0)
+		state.scene.AddChild(bg)
+	}
 	state.scene.AddChild(state.env)
-	state.system.SetKeyCallback(func(k, s int) { state.HandleKeys(k, s) })
-	state.screenxmin = float32(-state.env.Width()) + state.window.View.Max.X
-	state.screenxmax = 0
-	state.screenymin = float32(-state.env.Height()) + state.window.View.Max.Y
-	state.screenymax = 0
 
 	// Do this later so that the hud renders on top of things
 	state.scene.AddChild(state.hud)
@@ -887,17 +1484,13 @@ func Init(system *twodee.System, window *twodee.Window) (state *State, err error
 	state.textscore = system.NewText("font1-textures", 0, 0, 2, "")
 	state.hud.AddChild(state.textscore)
 
+	state.textpowers = system.NewText("font1-textures", 0, 72, 1, "")
+	state.hud.AddChild(state.textpowers)
+
 	state.textfps = system.NewText("font1-textures", 0, float32(state.window.View.Max.Y-32), 1, "")
 	state.hud.AddChild(state.textfps)
 	state.hud.SetZ(0.5)
-	state.nextlife = 400
-	state.SetScore(0)
-	state.ChangeMaxLives(1)
-	state.ChangeLives(1)
-	state.SetMaxHealth(3)
-	state.ChangeHealth(3)
-	state.running = true
-	state.Victory = false
+	state.Start()
 	return
 }
 
@@ -907,52 +1500,134 @@ type Splash struct {
 	system  *twodee.System
 	scene   *twodee.Scene
 	sprite  *twodee.Sprite
+	clock   *GameClock
 	started time.Time
+	next    func() Screen
 }
 
+// The splash texture must already be loaded, as NewGame does.
 func InitSplash(system *twodee.System, window *twodee.Window, frame int) (splash *Splash, err error) {
-	if system.LoadTexture("splash", "assets/splash-fw.png", twodee.IntNearest, 0); err != nil {
-		return
-	}
 	splash = &Splash{
 		running: true,
 		window:  window,
 		system:  system,
 		scene:   &twodee.Scene{},
+		clock:   NewGameClock(),
 	}
-	system.SetKeyCallback(func(k, s int) {
-		threshold := time.Duration(500) * time.Millisecond
-		if time.Now().After(splash.started.Add(threshold)) {
-			splash.running = false
-		}
-	})
-	fmt.Println(system.Textures)
 	splash.sprite = system.NewSprite("splash", 0, 0, int(window.View.Dx()), int(window.View.Dy()), 0)
 	splash.sprite.SetFrame(frame)
 	splash.scene.AddChild(splash.sprite)
-	splash.started = time.Now()
+	splash.started = splash.clock.Now()
 	return
 }
 
+func (s *Splash) Resume() {
+	s.system.SetKeyCallback(func(k, st int) {
+		threshold := time.Duration(500) * time.Millisecond
+		if s.clock.Now().After(s.started.Add(threshold)) {
+			s.running = false
+		}
+	})
+}
+
 func (s *Splash) Running() bool {
 	return s.running && s.window.Opened()
 }
 
+func (s *Splash) Next() Screen {
+	if s.next == nil {
+		return nil
+	}
+	return s.next()
+}
+
 func (s *Splash) Paint() {
+	s.clock.Advance(s.clock.Elapsed())
 	threshold := time.Duration(5) * time.Second
-	if time.Now().After(s.started.Add(threshold)) {
+	if s.clock.Now().After(s.started.Add(threshold)) {
 		s.running = false
 	}
 	s.system.Paint(s.scene)
 }
 
+var (
+	headless = flag.Int("headless", 0, "Run this many steps without a window and exit")
+	speed    = flag.Float64("speed", 1, "Game speed, less than 1 for slow motion")
+	seed     = flag.Int64("seed", 0, "Random seed, 0 picks one")
+	record   = flag.String("record", "", "Record input to this file")
+	replay   = flag.String("replay", "", "Play back input recorded to this file")
+	level    = flag.String("level", "", "Play just this level manifest")
+	campaign = flag.String("campaign", "assets/campaign.json", "Campaign to play")
+	progress = flag.String("progress", filepath.Join(os.Getenv("HOME"), ".tdos-progress.json"), "File to keep campaign progress in")
+	bindings = flag.String("bindings", filepath.Join(os.Getenv("HOME"), ".tdos-bindings.json"), "File to keep key bindings in")
+)
+
+// Works out what to play from the flags.  Recordings only ever hold one
+// level, so recording or replaying plays the first level of the campaign
+// unless another is given.
+func OpenCampaign() (c *Campaign, err error) {
+	if *level != "" {
+		return SingleLevel(*level), nil
+	}
+	if c, err = LoadCampaign(*campaign); err != nil {
+		return
+	}
+	if *record != "" || *replay != "" {
+		return SingleLevel(c.Levels[0]), nil
+	}
+	return
+}
+
+// Applies the seed, record and replay flags to a new state.
+func Configure(state *State) (err error) {
+	if *seed != 0 {
+		state.SetSeed(*seed)
+	}
+	if *replay != "" {
+		var r *Replay
+		if r, err = LoadReplay(*replay); err != nil {
+			return
+		}
+		if err = state.Replay(r); err != nil {
+			return
+		}
+	}
+	if *record != "" {
+		err = state.Record(*record)
+	}
+	return
+}
+
 func main() {
 	var (
-		splash *Splash
 		system *twodee.System
 		window *twodee.Window
+		game   *Game
 		err    error
 	)
+	flag.Parse()
+	c, err := OpenCampaign()
+	Check(err)
+	if *headless > 0 {
+		steps := *headless
+		_, err = c.Play(0, func(i int, stats *Stats) (state *State, err error) {
+			if state, err = InitHeadless(NewManualClock(), c.Levels[i]); err != nil {
+				return
+			}
+			if stats != nil {
+				state.SetStats(*stats)
+			}
+			if err = Configure(state); err != nil {
+				return
+			}
+			steps -= RunHeadless(state, steps, STEP_MS)
+			err = state.Close()
+			return
+		})
+		Check(err)
+		return
+	}
+	Check(c.LoadProgress(*progress))
 	system, err = twodee.Init()
 	Check(err)
 	defer system.Terminate()
@@ -965,39 +1640,11 @@ func main() {
 	}
 	system.Open(window)
 
-	if !DEBUG {
-		splash, err = InitSplash(system, window, 0)
-		Check(err)
-		for splash.Running() {
-			splash.Paint()
-		}
-		splash = nil
-	}
-
-	state, err := Init(system, window)
+	game, err = NewGame(system, window, c, *progress)
 	Check(err)
-	tick := time.Now()
-	state.UpdateViewport(0)
-	for state.Running() {
-		elapsed := time.Since(tick)
-		//fmt.Printf("Elapsed: %v\n", float32(elapsed) / float32(time.Millisecond))
-		tick = time.Now()
-		ms := Min(float32(elapsed)/float32(time.Millisecond), 50)
-		state.CheckKeys(ms)
-		state.Update(ms)
-		state.UpdateViewport(ms)
-		state.Paint(ms)
-	}
-
-	if !DEBUG {
-		frame := 1
-		if state.Victory {
-			frame = 2
-		}
-		splash, err = InitSplash(system, window, frame)
-		Check(err)
-		for splash.Running() {
-			splash.Paint()
-		}
-	}
+	game.speed = float32(*speed)
+	game.bindings, err = LoadBindings(*bindings)
+	Check(err)
+	game.screens.Push(game.Title())
+	game.screens.Run()
 }
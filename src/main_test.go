@@ -0,0 +1,158 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+// A state with a floor along y 500 and nothing else.
+func NewSlopeTestState() *State {
+	s := NewState(NewManualClock(), 800, 600)
+	s.SetBlockSize(32, 32)
+	s.width, s.height = 3000, 1000
+	for x := float32(0); x < 1600; x += 32 {
+		s.boundaries.Add(NewBody(x, 500, 32, 32))
+	}
+	return s
+}
+
+// Walks over a hill one block high, made of the given slopes either side of
+// a flat top, and back again.
+func testSlopeWalk(t *testing.T, up []int, down []int) {
+	var (
+		s    = NewSlopeTestState()
+		x    = float32(320)
+		b    = NewBody(200, 500-64, 34, 64)
+		last = b.Y
+	)
+	for _, kind := range up {
+		s.boundaries.Add(NewSlope(kind, x, 468, 32, 32))
+		x += 32
+	}
+	for i := 0; i < 8; i++ {
+		s.boundaries.Add(NewBody(x, 468, 32, 32))
+		x += 32
+	}
+	for _, kind := range down {
+		s.boundaries.Add(NewSlope(kind, x, 468, 32, 32))
+		x += 32
+	}
+	top := false
+	for i := 0; i < 1000; i++ {
+		b.VelocityX = 0.3
+		if i >= 500 {
+			b.VelocityX = -0.3
+		}
+		r := s.UpdateSprite(b, STEP_MS)
+		if r&(HITLEFT|HITRIGHT) != 0 {
+			t.Fatalf("step %v: blocked at %v,%v", i, b.X, b.Y)
+		}
+		if r&HITBOTTOM == 0 {
+			t.Fatalf("step %v: left the ground at %v,%v", i, b.X, b.Y)
+		}
+		if d := b.Y - last; d > 4 || d < -4 {
+			t.Fatalf("step %v: jumped %v at %v,%v", i, d, b.X, b.Y)
+		}
+		last = b.Y
+		top = top || b.Y == 468-64
+	}
+	if !top {
+		t.Errorf("Never reached the top of the hill")
+	}
+	if b.Y != 500-64 || b.X > 200 {
+		t.Errorf("Ended at %v,%v, not back on the floor at the start", b.X, b.Y)
+	}
+}
+
+func TestSlopeWalk45(t *testing.T) {
+	testSlopeWalk(t, []int{SLOPE_UP}, []int{SLOPE_DOWN})
+}
+
+func TestSlopeWalkHalf(t *testing.T) {
+	testSlopeWalk(t, []int{SLOPE_UP_LOW, SLOPE_UP_HIGH}, []int{SLOPE_DOWN_HIGH, SLOPE_DOWN_LOW})
+}
+
+func TestSlopeLand(t *testing.T) {
+	var (
+		s     = NewSlopeTestState()
+		slope = NewSlope(SLOPE_UP, 320, 468, 32, 32)
+		b     = NewBody(320-1, 300, 34, 64)
+	)
+	s.boundaries.Add(slope)
+	for i := 0; i < 200; i++ {
+		if r := s.UpdateSprite(b, STEP_MS); r&HITBOTTOM != 0 {
+			break
+		}
+	}
+	surface, _ := slope.Surface(b.X + b.Width/2)
+	if b.Y+b.Height != surface || !b.Grounded {
+		t.Errorf("Landed with feet at %v, grounded %v, slope is at %v", b.Y+b.Height, b.Grounded, surface)
+	}
+}
+
+func TestSlopeUnderside(t *testing.T) {
+	var (
+		s     = NewSlopeTestState()
+		slope = NewSlope(SLOPE_UP, 320, 300, 32, 32)
+		b     = NewBody(320, 400, 32, 64)
+	)
+	s.boundaries.Add(slope)
+	b.VelocityY = -2
+	if r := s.UpdateSprite(b, 100); r&HITTOP == 0 || b.Y != 332 {
+		t.Errorf("Jumping into a slope from below got %v at y %v, want HITTOP at 332", r, b.Y)
+	}
+}
+
+func TestSlopeHighSide(t *testing.T) {
+	var (
+		s = NewSlopeTestState()
+		// Too high to step up onto
+		slope = NewSlope(SLOPE_DOWN, 320, 436, 32, 32)
+		b     = NewBody(200, 500-64, 32, 64)
+	)
+	s.boundaries.Add(slope)
+	for i := 0; i < 200; i++ {
+		b.VelocityX = 0.3
+		if r := s.UpdateSprite(b, STEP_MS); r&HITRIGHT != 0 {
+			break
+		}
+	}
+	if b.X+b.Width != slope.X {
+		t.Errorf("Walked into the high side of a slope and ended at x %v", b.X)
+	}
+	// A slope on the ground is stepped up onto, like any block that high
+	s = NewSlopeTestState()
+	slope = NewSlope(SLOPE_DOWN, 320, 468, 32, 32)
+	s.boundaries.Add(slope)
+	b = NewBody(200, 500-64, 32, 64)
+	for i := 0; i < 100; i++ {
+		b.VelocityX = 0.3
+		s.UpdateSprite(b, STEP_MS)
+		var (
+			bottom       = b.Y + b.Height
+			ground, over = slope.Surface(b.X + b.Width/2)
+		)
+		if !over {
+			if b.X > slope.X {
+				break // Walked down off the far side
+			}
+			ground = slope.Y
+		}
+		if b.X+b.Width > slope.X && bottom > ground+1 {
+			t.Fatalf("step %v: walked into a slope's high side, at %v,%v", i, b.X, b.Y)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+)
+
+const (
+	MENU_INDENT = 32
+	MENU_LINE   = 40
+)
+
+// Menu is a titled list of text entries with a cursor that the arrow keys
+// move.  It lives in a scene of its own, which is added to whatever the menu
+// is shown over.
+type Menu struct {
+	Scene    *twodee.Scene
+	entries  []*twodee.Text
+	cursor   *twodee.Text
+	x        float32
+	top      float32
+	Selected int
+	Enabled  int // Only the first Enabled entries can be picked
+}
+
+func NewMenu(system *twodee.System, x float32, y float32, title string, entries []string) *Menu {
+	m := &Menu{
+		Scene:   &twodee.Scene{},
+		x:       x,
+		top:     y,
+		Enabled: len(entries),
+	}
+	if title != "" {
+		m.Scene.AddChild(system.NewText("font1-textures", x, y, 2, title))
+		m.top += 2 * MENU_LINE
+	}
+	for i, e := range entries {
+		t := system.NewText("font1-textures", x+MENU_INDENT, m.top+float32(i*MENU_LINE), 2, e)
+		m.entries = append(m.entries, t)
+		m.Scene.AddChild(t)
+	}
+	m.cursor = system.NewText("font1-textures", x, m.top, 2, ">")
+	m.Scene.AddChild(m.cursor)
+	return m
+}
+
+func (m *Menu) SetEntry(i int, text string) {
+	m.entries[i].SetText(text)
+}
+
+// Moves the cursor to entry i, if it can be picked.
+func (m *Menu) Select(i int) {
+	if i < 0 || i >= m.Enabled {
+		return
+	}
+	m.Selected = i
+	m.cursor.MoveTo(twodee.Pt(m.x, m.top+float32(i*MENU_LINE)))
+}
+
+// Moves the cursor for the arrow keys.  Returns true when Enter or Space
+// picks the selected entry.
+func (m *Menu) HandleKeys(key, state int) bool {
+	if state != 1 {
+		return false
+	}
+	switch key {
+	case twodee.KeyUp:
+		m.Select(m.Selected - 1)
+	case twodee.KeyDown:
+		m.Select(m.Selected + 1)
+	case twodee.KeyEnter, twodee.KeySpace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"fmt"
+	"time"
+)
+
+// The pause menu is a screen of its own, pushed over the level it pauses and
+// popped to carry on.  The level isn't stepped while it's covered, and the
+// game clock is stopped, so game time stands still.  The level is still
+// painted underneath, with the menu in its HUD.
+
+const (
+	PAUSE_RESUME = iota
+	PAUSE_RESTART
+	PAUSE_OPTIONS
+	PAUSE_QUIT
+)
+
+// The options menu has the speed, then an entry for each action, then back.
+const (
+	OPTIONS_SPEED   = 0
+	OPTIONS_ACTIONS = 1
+)
+
+// Game speeds the options menu goes through.
+var Speeds = []float32{1, 0.75, 0.5}
+
+type PauseScreen struct {
+	game        *Game
+	state       *State // The level paused
+	alpha       float32
+	tick        time.Time
+	pausemenu   *Menu
+	optionsmenu *Menu
+	menu        *Menu // The one showing
+	rebinding   int   // Action waiting for a key
+	joypause    JoyButton
+	running     bool
+}
+
+// Pauses state, which is painted alpha of the way between its last two steps
+// for as long as it's paused.
+func (g *Game) Pause(state *State, alpha float32) Screen {
+	var (
+		menux   = state.viewwidth/2 - 160
+		menuy   = state.viewheight / 4
+		options = make([]string, OPTIONS_ACTIONS+len(Actions)+1)
+	)
+	options[len(options)-1] = "BACK"
+	p := &PauseScreen{
+		game:        g,
+		state:       state,
+		alpha:       alpha,
+		tick:        time.Now(),
+		pausemenu:   NewMenu(g.system, menux, menuy, "PAUSED", []string{"RESUME", "RESTART LEVEL", "OPTIONS", "QUIT"}),
+		optionsmenu: NewMenu(g.system, menux, menuy, "OPTIONS", options),
+		running:     true,
+	}
+	// The button that paused is still down
+	p.joypause.Pressed(state.joystick, g.bindings)
+	p.ShowSpeed()
+	p.ShowBindings()
+	p.ShowMenu(p.pausemenu)
+	return p
+}
+
+func (p *PauseScreen) Resume() {
+	p.game.clock.Pause()
+	p.game.system.SetKeyCallback(func(k, s int) { p.HandleKeys(k, s) })
+}
+
+func (p *PauseScreen) Running() bool {
+	return p.running && p.game.window.Opened()
+}
+
+func (p *PauseScreen) Paint() {
+	ms := float32(time.Since(p.tick)) / float32(time.Millisecond)
+	p.tick = time.Now()
+	if p.joypause.Pressed(p.state.joystick, p.game.bindings) {
+		p.Close()
+	}
+	p.state.Paint(ms, p.alpha)
+}
+
+// Pops back to the level, which restarts or quits if the menu said to.
+func (p *PauseScreen) Next() Screen {
+	return nil
+}
+
+// Takes the menu off the level and lets it carry on.
+func (p *PauseScreen) Close() {
+	p.ShowMenu(nil)
+	p.running = false
+}
+
+func (p *PauseScreen) HandleKeys(key, state int) {
+	if p.rebinding != 0 {
+		if state == 1 {
+			// Esc gives up, unless it's PAUSE being bound
+			if key != twodee.KeyEsc || p.rebinding == INPUT_PAUSE {
+				err := p.game.bindings.Bind(p.rebinding, key)
+				if err == nil {
+					err = p.game.bindings.Save()
+				}
+				if err != nil {
+					fmt.Printf("[error]: %v\n", err)
+				}
+			}
+			p.rebinding = 0
+			p.ShowBindings()
+		}
+		return
+	}
+	if p.game.bindings.Is(INPUT_PAUSE, key) && state == 1 {
+		p.Close()
+		return
+	}
+	if !p.menu.HandleKeys(key, state) {
+		return
+	}
+	switch p.menu {
+	case p.pausemenu:
+		switch p.menu.Selected {
+		case PAUSE_RESUME:
+			p.Close()
+		case PAUSE_RESTART:
+			p.state.Restarting = true
+			p.state.running = false
+			p.Close()
+		case PAUSE_OPTIONS:
+			p.ShowMenu(p.optionsmenu)
+		case PAUSE_QUIT:
+			p.state.Quit = true
+			p.state.running = false
+			p.Close()
+		}
+	case p.optionsmenu:
+		i := p.menu.Selected
+		switch {
+		case i == OPTIONS_SPEED:
+			next := Speeds[0]
+			for i, speed := range Speeds {
+				if speed == p.game.speed && i+1 < len(Speeds) {
+					next = Speeds[i+1]
+				}
+			}
+			p.game.speed = next
+			p.ShowSpeed()
+		case i-OPTIONS_ACTIONS < len(Actions):
+			// Bind whatever key is pressed next
+			a := Actions[i-OPTIONS_ACTIONS]
+			p.rebinding = a.Input
+			p.optionsmenu.SetEntry(i, fmt.Sprintf("%-6v ?", a.Name))
+		default:
+			p.ShowMenu(p.pausemenu)
+		}
+	}
+}
+
+// Shows m over the level, or no menu if m is nil.
+func (p *PauseScreen) ShowMenu(m *Menu) {
+	if p.menu != nil {
+		p.state.hud.RemoveChild(p.menu.Scene)
+	}
+	p.menu = m
+	if m != nil {
+		m.Select(0)
+		p.state.hud.AddChild(m.Scene)
+	}
+}
+
+func (p *PauseScreen) ShowSpeed() {
+	p.optionsmenu.SetEntry(OPTIONS_SPEED, fmt.Sprintf("SPEED %v%%", int(p.game.speed*100)))
+}
+
+// Lists what's bound to each action in the options menu.
+func (p *PauseScreen) ShowBindings() {
+	for i, a := range Actions {
+		p.optionsmenu.SetEntry(OPTIONS_ACTIONS+i, fmt.Sprintf("%-6v %v", a.Name, p.game.bindings.Describe(a.Input)))
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Pickups are collected by touching them, and are gone once collected.
+// They're drawn from powerups-textures rather than the level's block
+// texture, so like BADGUY their blocks are given frame -1 in the manifest.
+
+const (
+	COIN_POINTS = 50
+)
+
+// The frame of powerups-textures each pickup is drawn with.
+var PickupFrames = map[int]int{
+	COIN:    4,
+	HEART:   3,
+	ONEUP:   0,
+	POWERUP: 5,
+}
+
+type Pickup struct {
+	Body *Body
+	Type int
+}
+
+// Creates a pickup resting on the floor of the block at x, y.
+func (s *State) NewPickup(t int, x float32, y float32) *Pickup {
+	var (
+		texture = s.textures["powerups-textures"]
+		frame   = PickupFrames[t]
+		width   = (texture.Frames[frame][1] - texture.Frames[frame][0]) * 2
+		height  = texture.Height * 2
+		px      = x + (s.blockwidth-float32(width))/2
+		py      = y + s.blockheight - float32(height)
+	)
+	p := &Pickup{
+		Body: s.NewBody("powerups-textures", px, py, width, height, t),
+		Type: t,
+	}
+	p.Body.SetFrame(frame)
+	return p
+}
+
+func (s *State) Collect(p *Pickup) {
+	switch p.Type {
+	case COIN:
+		s.SetScore(s.Score() + COIN_POINTS)
+	case HEART:
+		s.ChangeHealth(1)
+	case ONEUP:
+		s.ChangeMaxLives(1)
+		s.ChangeLives(1)
+	case POWERUP:
+		s.GrantNextPowerup()
+	}
+	s.entities.Despawn(p)
+}
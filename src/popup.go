@@ -0,0 +1,70 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"time"
+)
+
+// Popups are bits of text, like the points a kill scored, that float up
+// from somewhere in the level and then vanish.  They're only drawn, so
+// there are none without a system.
+
+const (
+	POPUP_MS    = 800
+	POPUP_SPEED = 0.05 // How fast they rise
+)
+
+type Popup struct {
+	Text    *twodee.Text
+	X       float32
+	Y       float32
+	started time.Time
+}
+
+// Shows text centred on x with its bottom at y.
+func (s *State) ShowPopup(x float32, y float32, text string) {
+	if s.system == nil {
+		return
+	}
+	t := s.system.NewText("font1-textures", 0, 0, 1, text)
+	p := &Popup{
+		Text:    t,
+		X:       x - t.Width()/2,
+		Y:       y - t.Height(),
+		started: s.clock.Now(),
+	}
+	t.MoveTo(twodee.Pt(p.X, p.Y))
+	s.env.AddChild(t)
+	s.popups = append(s.popups, p)
+}
+
+func (s *State) UpdatePopups(ms float32) {
+	var (
+		showing = s.popups[:0]
+		expired = s.clock.Now().Add(-time.Duration(POPUP_MS) * time.Millisecond)
+	)
+	for _, p := range s.popups {
+		if p.started.Before(expired) {
+			s.env.RemoveChild(p.Text)
+			continue
+		}
+		p.Y -= POPUP_SPEED * ms
+		p.Text.MoveTo(twodee.Pt(p.X, p.Y))
+		showing = append(showing, p)
+	}
+	s.popups = showing
+}
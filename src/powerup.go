@@ -0,0 +1,110 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+)
+
+// Power-ups are abilities the player gathers on the way.  Throwing isn't one,
+// the player can always throw.  Following the
+// README's idea the player starts with none and grows stronger as they
+// collect lives: coming to hold enough lives for a rung of PowerupLadder
+// grants its power-up, and POWERUP pickups grant the next one early.  Taking
+// damage or losing a life loses them all, except that a shield takes a hit
+// in their place and is lost on its own.
+//
+// Lost power-ups stay lost.  A rung is only granted as the lives held climb
+// past it, so respawning gives nothing back, and after a 1-up only the rung
+// for the new number of lives is granted.  Losing a life and gaining it
+// back climbs that rung again.
+
+const (
+	POWERUP_DOUBLEJUMP = 1 << iota // One more jump in the air
+	POWERUP_SPEED      = 1 << iota // Walk and run faster
+	POWERUP_SHIELD     = 1 << iota // Takes a hit instead of health
+)
+
+type Powerup struct {
+	Name  string
+	Power int
+	Lives int // Granted when the player comes to hold this many lives
+}
+
+// In the order they're earned, which is also the order the HUD lists them.
+var PowerupLadder = []Powerup{
+	{"DOUBLE JUMP", POWERUP_DOUBLEJUMP, 2},
+	{"SPEED", POWERUP_SPEED, 3},
+	{"SHIELD", POWERUP_SHIELD, 4},
+}
+
+func (p *Player) HasPowerup(power int) bool {
+	return p.Powerups&power == power
+}
+
+// Gives the player power-ups.  Ones they already have are ignored.
+func (s *State) GrantPowerup(power int) {
+	s.player.Powerups |= power
+	s.ShowPowerups()
+}
+
+// Takes power-ups away from the player.
+func (s *State) LosePowerup(power int) {
+	s.player.Powerups &^= power
+	s.ShowPowerups()
+}
+
+// Grants the power-ups for the rungs of the ladder passed going from holding
+// from lives to holding to.
+func (s *State) ClimbPowerupLadder(from int, to int) {
+	for _, p := range PowerupLadder {
+		if from < p.Lives && p.Lives <= to {
+			s.GrantPowerup(p.Power)
+		}
+	}
+}
+
+// Grants the lowest power-up on the ladder the player doesn't have yet.
+func (s *State) GrantNextPowerup() {
+	for _, p := range PowerupLadder {
+		if !s.player.HasPowerup(p.Power) {
+			s.GrantPowerup(p.Power)
+			return
+		}
+	}
+}
+
+// The player has been hurt.  Returns true if a shield took the hit.
+func (s *State) HurtPowerups() bool {
+	if s.player.HasPowerup(POWERUP_SHIELD) {
+		s.LosePowerup(POWERUP_SHIELD)
+		return true
+	}
+	s.LosePowerup(s.player.Powerups)
+	return false
+}
+
+func (s *State) ShowPowerups() {
+	if s.textpowers == nil {
+		return
+	}
+	names := []string{}
+	for _, p := range PowerupLadder {
+		if s.player.HasPowerup(p.Power) {
+			names = append(names, p.Name)
+		}
+	}
+	s.textpowers.SetText(strings.Join(names, " "))
+}
@@ -0,0 +1,105 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Starts level1 with the player able to be hurt.
+func NewPowerupTestState(t *testing.T) (*State, *ManualClock) {
+	clock := NewManualClock()
+	s, err := InitHeadless(clock, "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ChangeMaxLives(4)
+	vincible(s, clock)
+	return s, clock
+}
+
+// Lets the moment after a hit, when the player can't be hurt, run out.
+func vincible(s *State, clock *ManualClock) {
+	clock.Advance(time.Second)
+	s.player.Health.Update()
+}
+
+func TestPowerupLadder(t *testing.T) {
+	s, clock := NewPowerupTestState(t)
+	if s.player.Powerups != 0 {
+		t.Fatalf("Started with power-ups %b", s.player.Powerups)
+	}
+	s.ChangeLives(1)
+	if s.player.Powerups != POWERUP_DOUBLEJUMP {
+		t.Fatalf("Got power-ups %b at 2 lives, want double jump", s.player.Powerups)
+	}
+	s.ChangeHealth(-1)
+	if s.player.Powerups != 0 {
+		t.Errorf("Kept power-ups %b after a hit", s.player.Powerups)
+	}
+	vincible(s, clock)
+	// A 1-up only grants the rung it climbs to
+	s.ChangeLives(1)
+	if s.player.Powerups != POWERUP_SPEED {
+		t.Errorf("Got power-ups %b at 3 lives after losing double jump, want speed", s.player.Powerups)
+	}
+	// Losing a life loses them all, and climbing back grants the rung again
+	s.ChangeLives(-1)
+	if s.player.Powerups != 0 {
+		t.Errorf("Kept power-ups %b after losing a life", s.player.Powerups)
+	}
+	s.ChangeLives(1)
+	if s.player.Powerups != POWERUP_SPEED {
+		t.Errorf("Got power-ups %b climbing back to 3 lives, want speed", s.player.Powerups)
+	}
+	s.GrantNextPowerup()
+	if s.player.Powerups != POWERUP_SPEED|POWERUP_DOUBLEJUMP {
+		t.Errorf("Got power-ups %b from a pickup, want double jump added", s.player.Powerups)
+	}
+}
+
+func TestPowerupRespawn(t *testing.T) {
+	s, _ := NewPowerupTestState(t)
+	s.ChangeLives(2)
+	s.UpdateViewport(0)
+	s.player.Body.Y = s.height + 2000
+	s.Step(STEP_MS)
+	if lives := s.livesbar.Available(); lives != 2 {
+		t.Fatalf("Have %v lives after falling off, want 2", lives)
+	}
+	if s.player.Powerups != 0 {
+		t.Errorf("Respawned with power-ups %b", s.player.Powerups)
+	}
+}
+
+func TestShield(t *testing.T) {
+	s, clock := NewPowerupTestState(t)
+	s.GrantPowerup(POWERUP_SHIELD | POWERUP_SPEED)
+	hp := s.player.HP
+	if s.ChangeHealth(-1) != hp {
+		t.Errorf("Shield didn't take the hit")
+	}
+	if s.player.Powerups != POWERUP_SPEED {
+		t.Errorf("Got power-ups %b after the shield took a hit, want speed", s.player.Powerups)
+	}
+	vincible(s, clock)
+	if s.ChangeHealth(-1) != hp-1 {
+		t.Errorf("Hit without a shield left %v HP, want %v", s.player.HP, hp-1)
+	}
+	if s.player.Powerups != 0 {
+		t.Errorf("Kept power-ups %b after a hit", s.player.Powerups)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// Projectiles are thrown things that fly until they hit a creature, a wall
+// or the floor, or run out of time.  They're kept in a pool on the State
+// and reused, so at most PROJECTILE_POOL can be in the air at once.
+
+const (
+	PROJECTILE_POOL = 4
+	PROJECTILE_MS   = 1500 // How long one flies for at most
+)
+
+type Projectile struct {
+	Body   *Body
+	Active bool
+	thrown time.Time
+}
+
+// Throws a projectile from x, y.  Returns nil if the pool is all in the
+// air.
+func (s *State) Throw(x float32, y float32, vx float32, vy float32, floating bool) *Projectile {
+	var p *Projectile
+	for _, q := range s.pool {
+		if !q.Active {
+			p = q
+			break
+		}
+	}
+	if p == nil {
+		if len(s.pool) == PROJECTILE_POOL {
+			return nil
+		}
+		var (
+			texture = s.textures["projectile-textures"]
+			width   = (texture.Frames[0][1] - texture.Frames[0][0]) * 2
+			height  = texture.Height * 2
+		)
+		p = &Projectile{Body: s.NewBody("projectile-textures", x, y, width, height, PLAYER)}
+		s.pool = append(s.pool, p)
+	}
+	p.Body.MoveTo(x-p.Body.Width/2, y-p.Body.Height/2)
+	p.Body.SavePosition()
+	p.Body.VelocityX = vx
+	p.Body.VelocityY = vy
+	p.Body.Floating = floating
+	p.Body.SetFrame(0)
+	p.Active = true
+	p.thrown = s.clock.Now()
+	s.entities.Spawn(p)
+	return p
+}
+
+// Throws a projectile from the player's hand the way they're facing.
+func (s *State) PlayerThrow() {
+	var (
+		p   = s.player
+		dir = float32(1)
+	)
+	if p.State&FACING_LEFT == FACING_LEFT {
+		dir = -1
+	}
+	x := p.Body.X + p.Body.Width/2 + dir*p.Body.Width/2
+	s.Throw(x, p.Body.Y+p.Body.Height/3, dir*p.ThrowSpeed+p.Body.VelocityX, -p.ThrowLift, false)
+}
+
+// Puts a projectile back in the pool.
+func (s *State) Spent(p *Projectile) {
+	p.Active = false
+	s.entities.Despawn(p)
+}
+
+// Moves every projectile in the air, and hurts the first creature each one
+// hits.  A creature still flashing from a hit just stops it.
+func (s *State) UpdateProjectiles(ms float32) {
+	for _, p := range s.entities.Projectiles {
+		if !p.Active {
+			continue
+		}
+		var (
+			age    = s.clock.Now().Sub(p.thrown)
+			result = s.UpdateSprite(p.Body, ms)
+		)
+		if result&(HITLEFT|HITRIGHT|HITTOP|HITBOTTOM) != 0 ||
+			age > time.Duration(PROJECTILE_MS)*time.Millisecond ||
+			!s.Visible(p.Body) {
+			s.Spent(p)
+			continue
+		}
+		p.Body.SetFrame(int(age/(time.Duration(80)*time.Millisecond)) % 2)
+		for _, c := range s.entities.Creatures {
+			if !c.Dying() && p.Body.CollidesWith(c.Body) {
+				s.HurtCreature(c, 1)
+				s.Spent(p)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+// Checks the player can throw from the start, and a rock kills a creature
+// in its way and scores its points.
+func TestThrowKills(t *testing.T) {
+	s, err := InitHeadless(NewManualClock(), "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateViewport(0)
+	for i := 0; i < 120; i++ {
+		s.Step(STEP_MS)
+	}
+	var (
+		p     = s.player.Body
+		c     = s.NewMushroom(p.X+p.Width+200, p.Y+p.Height) // Where the rock comes down
+		score = s.Score()
+	)
+	if s.player.Powerups != 0 {
+		t.Fatalf("Started with power-ups %b", s.player.Powerups)
+	}
+	c.HP = 1
+	c.Think = nil
+	s.entities.Spawn(c)
+	s.SetKey('X', 1)
+	for i := 0; i < 120 && !c.Dying(); i++ {
+		s.Step(STEP_MS)
+	}
+	if !c.Dying() {
+		t.Fatalf("Rock didn't kill the creature")
+	}
+	if got, want := s.Score(), score+c.Points; got != want {
+		t.Errorf("Score is %v after the kill, want %v", got, want)
+	}
+}
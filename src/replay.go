@@ -0,0 +1,206 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// A recording is everything needed to play a session again: the seed, the
+// level and the input for every step.  Input rarely changes from one step to
+// the next so it is stored as runs.
+//
+//   "TDOS" version(byte) seed(varint) step(float32 bits, uvarint)
+//   level(uvarint length, bytes) { count(uvarint) input(byte) }...
+//
+// The version goes up whenever the INPUT_ bits or what the player does with
+// them change, since a recording only plays back the same with both.
+// Version 2 added the RUN and THROW bits, and changed what JUMP and DOWN do.
+
+const (
+	REPLAY_MAGIC     = "TDOS"
+	REPLAY_VERSION   = 2
+	REPLAY_MAX_LEVEL = 4096 // Longest level path a recording can hold
+)
+
+type Recorder struct {
+	file  *os.File
+	w     *bufio.Writer
+	input int
+	count uint64
+}
+
+func NewRecorder(path string, seed int64, step float32, level string) (r *Recorder, err error) {
+	var f *os.File
+	if f, err = os.Create(path); err != nil {
+		return
+	}
+	r = &Recorder{
+		file: f,
+		w:    bufio.NewWriter(f),
+	}
+	r.w.WriteString(REPLAY_MAGIC)
+	r.w.WriteByte(REPLAY_VERSION)
+	r.putVarint(seed)
+	r.putUvarint(uint64(math.Float32bits(step)))
+	r.putUvarint(uint64(len(level)))
+	r.w.WriteString(level)
+	return
+}
+
+func (r *Recorder) putVarint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	r.w.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (r *Recorder) putUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	r.w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (r *Recorder) flush() {
+	if r.count > 0 {
+		r.putUvarint(r.count)
+		r.w.WriteByte(byte(r.input))
+	}
+	r.count = 0
+}
+
+// Records the input for one step.
+func (r *Recorder) Record(input int) {
+	if input != r.input {
+		r.flush()
+		r.input = input
+	}
+	r.count++
+}
+
+func (r *Recorder) Close() (err error) {
+	r.flush()
+	if err = r.w.Flush(); err != nil {
+		r.file.Close()
+		return
+	}
+	return r.file.Close()
+}
+
+type Replay struct {
+	Seed  int64
+	Step  float32
+	Level string
+	file  *os.File
+	r     *bufio.Reader
+	input int
+	count uint64
+}
+
+func LoadReplay(path string) (p *Replay, err error) {
+	var (
+		f     *os.File
+		magic = make([]byte, len(REPLAY_MAGIC))
+		v     byte
+		step  uint64
+		n     uint64
+	)
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	p = &Replay{
+		file: f,
+		r:    bufio.NewReader(f),
+	}
+	if _, err = io.ReadFull(p.r, magic); err != nil {
+		return
+	}
+	if string(magic) != REPLAY_MAGIC {
+		err = fmt.Errorf("%v is not a recording", path)
+		return
+	}
+	if v, err = p.r.ReadByte(); err != nil {
+		return
+	}
+	if v != REPLAY_VERSION {
+		err = fmt.Errorf("%v has unknown version %v", path, v)
+		return
+	}
+	if p.Seed, err = binary.ReadVarint(p.r); err != nil {
+		return
+	}
+	if step, err = binary.ReadUvarint(p.r); err != nil {
+		return
+	}
+	p.Step = math.Float32frombits(uint32(step))
+	if n, err = binary.ReadUvarint(p.r); err != nil {
+		return
+	}
+	if n > REPLAY_MAX_LEVEL {
+		err = fmt.Errorf("%v has a level path %v bytes long", path, n)
+		return
+	}
+	level := make([]byte, n)
+	if _, err = io.ReadFull(p.r, level); err != nil {
+		return
+	}
+	p.Level = string(level)
+	return
+}
+
+func (p *Replay) Close() error {
+	return p.file.Close()
+}
+
+// Returns the input for the next step, and false once the recording is
+// used up.
+func (p *Replay) Next() (input int, ok bool) {
+	if p.count == 0 {
+		var (
+			count uint64
+			b     byte
+			err   error
+		)
+		if count, err = binary.ReadUvarint(p.r); err != nil {
+			return 0, false
+		}
+		if b, err = p.r.ReadByte(); err != nil {
+			return 0, false
+		}
+		p.count = count
+		p.input = int(b)
+	}
+	p.count--
+	return p.input, true
+}
+
+// Checks the recording was made against the level and step a state is
+// about to play with.
+func (p *Replay) Check(level string, step float32) error {
+	if p.Level != level {
+		return fmt.Errorf("recording is of %v, not %v", p.Level, level)
+	}
+	if p.Step != step {
+		return fmt.Errorf("recording steps %vms, not %vms", p.Step, step)
+	}
+	return nil
+}
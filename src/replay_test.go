@@ -0,0 +1,89 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		path   = filepath.Join(dir, "replay")
+		inputs = []int{0, 0, INPUT_RIGHT, INPUT_RIGHT | INPUT_JUMP, INPUT_RIGHT, 0}
+	)
+	r, err := NewRecorder(path, 42, STEP_MS, "assets/level1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		r.Record(input)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.Seed != 42 {
+		t.Errorf("Seed is %v, want 42", p.Seed)
+	}
+	if err = p.Check("assets/level1.json", STEP_MS); err != nil {
+		t.Error(err)
+	}
+	if err = p.Check("assets/level2.json", STEP_MS); err == nil {
+		t.Error("Check passed for the wrong level")
+	}
+	for i, want := range inputs {
+		if input, ok := p.Next(); !ok || input != want {
+			t.Errorf("step %v got %v %v, want %v", i, input, ok, want)
+		}
+	}
+	if _, ok := p.Next(); ok {
+		t.Error("Next kept going after the recording ended")
+	}
+}
+
+func TestReplayCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{
+		"empty":   "",
+		"magic":   "NOPE",
+		"version": REPLAY_MAGIC + "\x09",
+		// A level path claiming to be 2^62 bytes long
+		"level": REPLAY_MAGIC + "\x01\x00\x00\x80\x80\x80\x80\x80\x80\x80\x80\x40",
+	} {
+		path := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadReplay(path); err == nil {
+			t.Errorf("%v recording loaded", name)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"time"
+)
+
+// Screen is anything that takes over the window for a while: the title, the
+// world map, a level, the pause menu over it, the splashes in between.
+// Screens are kept on a stack and the top one is painted until it stops
+// running.  Then it's replaced by the screen it says comes next, or popped
+// if it gives none, and the screen underneath carries on.
+type Screen interface {
+	Running() bool
+	Paint()
+	Next() Screen // nil to pop back to the screen underneath
+	Resume()      // Called each time it comes to the top, to take the keys
+}
+
+// How long the window stays black when one screen replaces another.
+const TRANSITION_MS = 200
+
+type ScreenStack struct {
+	window  *twodee.Window
+	system  *twodee.System
+	screens []Screen
+}
+
+func NewScreenStack(system *twodee.System, window *twodee.Window) *ScreenStack {
+	return &ScreenStack{
+		window: window,
+		system: system,
+	}
+}
+
+// Puts s over the current screen, which stops being painted until s is
+// popped.
+func (st *ScreenStack) Push(s Screen) {
+	st.screens = append(st.screens, s)
+	s.Resume()
+}
+
+func (st *ScreenStack) Top() Screen {
+	if len(st.screens) == 0 {
+		return nil
+	}
+	return st.screens[len(st.screens)-1]
+}
+
+// Paints the top screen until the stack is empty.  Once the window is closed
+// every screen is popped without painting, so each can clean up in Next.
+func (st *ScreenStack) Run() {
+	for s := st.Top(); s != nil; s = st.Top() {
+		if s.Running() {
+			s.Paint()
+			continue
+		}
+		st.screens = st.screens[:len(st.screens)-1]
+		next := s.Next()
+		switch {
+		case !st.window.Opened():
+		case next != nil:
+			st.Push(next)
+			st.Push(NewTransition(st.system, st.window))
+		case st.Top() != nil:
+			st.Top().Resume()
+		}
+	}
+}
+
+// Transition holds the window black for a moment when one screen replaces
+// another, so the game doesn't cut straight from one to the next, and keys
+// pressed for the last screen don't land in the next.
+type Transition struct {
+	window  *twodee.Window
+	system  *twodee.System
+	scene   *twodee.Scene
+	started time.Time
+}
+
+func NewTransition(system *twodee.System, window *twodee.Window) *Transition {
+	return &Transition{
+		window:  window,
+		system:  system,
+		scene:   &twodee.Scene{},
+		started: time.Now(),
+	}
+}
+
+func (t *Transition) Resume() {
+	t.system.SetClearColor(0, 0, 0, 255)
+	t.system.SetKeyCallback(func(k, s int) {})
+}
+
+func (t *Transition) Running() bool {
+	return time.Since(t.started) < TRANSITION_MS*time.Millisecond && t.window.Opened()
+}
+
+func (t *Transition) Paint() {
+	t.system.Paint(t.scene)
+}
+
+func (t *Transition) Next() Screen {
+	return nil
+}
+
+const (
+	SPLASH_TITLE    = 0
+	SPLASH_GAMEOVER = 1
+	SPLASH_VICTORY  = 2
+)
+
+// Game is what every screen shares.
+type Game struct {
+	system   *twodee.System
+	window   *twodee.Window
+	campaign *Campaign
+	progress string // Where campaign progress is saved
+	clock    *GameClock
+	speed    float32 // Kept from level to level
+	bindings *Bindings
+	screens  *ScreenStack
+}
+
+// Loads the textures the screens outside of levels need: the splash, and
+// the first level's for everything else.
+func NewGame(system *twodee.System, window *twodee.Window, campaign *Campaign, progress string) (g *Game, err error) {
+	var first *Level
+	if first, err = LoadLevelManifest(campaign.Levels[0]); err != nil {
+		return
+	}
+	if err = LoadTextures(system, first.Textures); err != nil {
+		return
+	}
+	if err = LoadTextures(system, []TexInfo{{"splash", "assets/splash-fw.png", 0}}); err != nil {
+		return
+	}
+	g = &Game{
+		system:   system,
+		window:   window,
+		campaign: campaign,
+		progress: progress,
+		clock:    NewGameClock(),
+		speed:    1,
+		bindings: DefaultBindings(),
+		screens:  NewScreenStack(system, window),
+	}
+	return
+}
+
+// Shows a frame of the splash, then moves on to next.
+func (g *Game) Splash(frame int, next func() Screen) Screen {
+	if DEBUG {
+		return next()
+	}
+	splash, err := InitSplash(g.system, g.window, frame)
+	Check(err)
+	splash.next = next
+	return splash
+}
+
+// Title is the title splash with the main menu over it.
+type Title struct {
+	game    *Game
+	scene   *twodee.Scene
+	menu    *Menu
+	actions []func() Screen
+	next    func() Screen
+	running bool
+}
+
+func (g *Game) Title() Screen {
+	t := &Title{
+		game:    g,
+		scene:   &twodee.Scene{},
+		running: true,
+	}
+	var (
+		entries = []string{"PLAY"}
+		w       = g.window.View.Dx()
+		h       = g.window.View.Dy()
+	)
+	t.actions = []func() Screen{
+		func() Screen { return g.Level(0, nil) },
+	}
+	if g.campaign.Unlocked > 1 {
+		entries = append(entries, "LEVEL SELECT")
+		t.actions = append(t.actions, g.LevelSelect)
+	}
+	entries = append(entries, "CREDITS", "QUIT")
+	t.actions = append(t.actions, g.Credits, nil)
+	sprite := g.system.NewSprite("splash", 0, 0, int(w), int(h), 0)
+	sprite.SetFrame(SPLASH_TITLE)
+	t.scene.AddChild(sprite)
+	t.menu = NewMenu(g.system, w/2-160, h-float32(len(entries)*MENU_LINE)-32, "", entries)
+	t.menu.Select(0)
+	t.scene.AddChild(t.menu.Scene)
+	return t
+}
+
+func (t *Title) Resume() {
+	t.game.system.SetKeyCallback(func(k, s int) { t.HandleKeys(k, s) })
+}
+
+func (t *Title) HandleKeys(key, state int) {
+	if key == twodee.KeyEsc && state == 1 {
+		t.running = false
+		return
+	}
+	if t.menu.HandleKeys(key, state) {
+		t.next = t.actions[t.menu.Selected]
+		t.running = false
+	}
+}
+
+func (t *Title) Running() bool {
+	return t.running && t.game.window.Opened()
+}
+
+func (t *Title) Paint() {
+	t.game.system.Paint(t.scene)
+}
+
+func (t *Title) Next() Screen {
+	if t.next == nil {
+		return nil
+	}
+	return t.next()
+}
+
+func (g *Game) LevelSelect() Screen {
+	m, err := InitWorldMap(g.system, g.window, g.campaign)
+	Check(err)
+	m.game = g
+	return m
+}
+
+func (m *WorldMap) Next() Screen {
+	if m.Selected < 0 {
+		return m.game.Title()
+	}
+	return m.game.Level(m.Selected, nil)
+}
+
+// LevelScreen plays level i of the campaign, stepping the state at a fixed
+// rate however fast it's painted.  It pushes the pause screen over itself.
+type LevelScreen struct {
+	game        *Game
+	index       int
+	stats       *Stats
+	state       *State
+	tick        time.Time
+	accumulated float32
+	pausing     bool // The pause key went down
+	joypause    JoyButton
+}
+
+// Starts level i, carrying stats in from the level before if there was one.
+func (g *Game) Level(i int, stats *Stats) Screen {
+	state, err := Init(g.system, g.window, g.clock, g.campaign.Levels[i])
+	Check(err)
+	if stats != nil {
+		state.SetStats(*stats)
+	}
+	Check(Configure(state))
+	state.SetBindings(g.bindings)
+	state.UpdateViewport(0)
+	return &LevelScreen{
+		game:  g,
+		index: i,
+		stats: stats,
+		state: state,
+	}
+}
+
+// Carries on from wherever the level was, without catching up on the time
+// it spent covered.
+func (l *LevelScreen) Resume() {
+	var (
+		g = l.game
+		c = l.state.level.ClearColor
+	)
+	g.system.SetClearColor(c[0], c[1], c[2], c[3])
+	g.system.SetKeyCallback(func(k, s int) {
+		if s == 1 && g.bindings.Is(INPUT_PAUSE, k) {
+			l.pausing = true
+		}
+	})
+	l.state.SetSpeed(g.speed)
+	g.clock.Resume()
+	g.clock.Elapsed()
+	l.tick = time.Now()
+}
+
+func (l *LevelScreen) Running() bool {
+	return l.state.Running()
+}
+
+func (l *LevelScreen) Paint() {
+	var (
+		state = l.state
+		clock = l.game.clock
+		ms    = float32(time.Since(l.tick)) / float32(time.Millisecond)
+	)
+	l.tick = time.Now()
+	if l.joypause.Pressed(state.joystick, state.bindings) || l.pausing {
+		l.pausing = false
+		l.game.screens.Push(l.game.Pause(state, l.accumulated/STEP_MS))
+		return
+	}
+	clock.SetScale(state.Speed())
+	l.accumulated = Min(l.accumulated+float32(clock.Elapsed())/float32(time.Millisecond), MAX_FRAME_MS)
+	for l.accumulated >= STEP_MS && state.Running() {
+		state.Step(STEP_MS)
+		l.accumulated -= STEP_MS
+	}
+	state.Paint(ms, l.accumulated/STEP_MS)
+}
+
+func (l *LevelScreen) Next() Screen {
+	var (
+		g     = l.game
+		state = l.state
+	)
+	Check(state.Close())
+	g.speed = state.Speed()
+	switch {
+	case !g.window.Opened():
+		return nil
+	case state.Restarting:
+		return g.Level(l.index, l.stats)
+	case state.Quit:
+		return g.Title()
+	case !state.Victory:
+		return g.Splash(SPLASH_GAMEOVER, g.Title)
+	}
+	g.campaign.Unlock(l.index + 1)
+	Check(g.campaign.SaveProgress(g.progress))
+	if l.index+1 == len(g.campaign.Levels) {
+		return g.Splash(SPLASH_VICTORY, g.Credits)
+	}
+	var (
+		next  = l.index + 1
+		stats = state.Stats()
+	)
+	return g.Splash(SPLASH_VICTORY, func() Screen { return g.Level(next, &stats) })
+}
+
+var CreditsText = []string{
+	"THE DESTINY OF SPECIES",
+	"",
+	"BY ARNE ROOMANN-KURRIK",
+	"FOR LUDUM DARE 24",
+	"",
+	"THANKS FOR PLAYING!",
+}
+
+// Credits rolls until a key is pressed or it times out, then goes back to
+// the title.
+type Credits struct {
+	game    *Game
+	scene   *twodee.Scene
+	clock   *GameClock
+	started time.Time
+	running bool
+}
+
+func (g *Game) Credits() Screen {
+	c := &Credits{
+		game:    g,
+		scene:   &twodee.Scene{},
+		clock:   NewGameClock(),
+		running: true,
+	}
+	for i, line := range CreditsText {
+		y := float32(WORLDMAP_TOP + i*MENU_LINE)
+		c.scene.AddChild(g.system.NewText("font1-textures", WORLDMAP_LEFT, y, 2, line))
+	}
+	c.started = c.clock.Now()
+	return c
+}
+
+func (c *Credits) Resume() {
+	c.game.system.SetKeyCallback(func(k, s int) {
+		threshold := time.Duration(500) * time.Millisecond
+		if s == 1 && c.clock.Now().After(c.started.Add(threshold)) {
+			c.running = false
+		}
+	})
+}
+
+func (c *Credits) Running() bool {
+	return c.running && c.game.window.Opened()
+}
+
+func (c *Credits) Paint() {
+	c.clock.Advance(c.clock.Elapsed())
+	threshold := time.Duration(10) * time.Second
+	if c.clock.Now().After(c.started.Add(threshold)) {
+		c.running = false
+	}
+	c.game.system.Paint(c.scene)
+}
+
+func (c *Credits) Next() Screen {
+	return c.game.Title()
+}
@@ -0,0 +1,102 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"./twodee"
+	"fmt"
+)
+
+const (
+	WORLDMAP_LEFT = 64
+	WORLDMAP_TOP  = 64
+)
+
+// WorldMap lists the levels of a campaign and lets the player start from
+// any they have unlocked.  Like Splash it has its own scene and runs until
+// the player is done with it.
+type WorldMap struct {
+	running    bool
+	window     *twodee.Window
+	system     *twodee.System
+	scene      *twodee.Scene
+	menu       *Menu
+	game       *Game
+	clearcolor [4]uint8
+	Selected   int // Level to start from, or -1 to go back
+}
+
+func InitWorldMap(system *twodee.System, window *twodee.Window, campaign *Campaign) (m *WorldMap, err error) {
+	var first *Level
+	if first, err = LoadLevelManifest(campaign.Levels[0]); err != nil {
+		return
+	}
+	// The map is drawn with the first level's textures, which NewGame loads
+	names := make([]string, len(campaign.Levels))
+	for i, path := range campaign.Levels {
+		names[i] = fmt.Sprintf("%v ???", i+1)
+		if i < campaign.Unlocked {
+			var l *Level
+			if l, err = LoadLevelManifest(path); err != nil {
+				return
+			}
+			names[i] = fmt.Sprintf("%v %v", i+1, l.Name)
+		}
+	}
+	m = &WorldMap{
+		running: true,
+		window:  window,
+		system:  system,
+		scene:   &twodee.Scene{},
+		menu:    NewMenu(system, WORLDMAP_LEFT, WORLDMAP_TOP, campaign.Name, names),
+	}
+	m.menu.Enabled = campaign.Unlocked
+	m.menu.Select(campaign.Unlocked - 1)
+	m.Selected = m.menu.Selected
+	m.scene.AddChild(m.menu.Scene)
+	m.clearcolor = first.ClearColor
+	return
+}
+
+// The map is drawn over the first level's clear colour.
+func (m *WorldMap) Resume() {
+	c := m.clearcolor
+	m.system.SetClearColor(c[0], c[1], c[2], c[3])
+	m.system.SetKeyCallback(func(k, s int) { m.HandleKeys(k, s) })
+}
+
+func (m *WorldMap) HandleKeys(key, state int) {
+	if key == twodee.KeyEsc && state == 1 {
+		m.Selected = -1
+		m.running = false
+		return
+	}
+	if m.menu.HandleKeys(key, state) {
+		m.Selected = m.menu.Selected
+		m.running = false
+	}
+}
+
+func (m *WorldMap) Running() bool {
+	if !m.window.Opened() {
+		m.Selected = -1
+		return false
+	}
+	return m.running
+}
+
+func (m *WorldMap) Paint() {
+	m.system.Paint(m.scene)
+}